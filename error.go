@@ -9,6 +9,11 @@ func (e *WarpError) Error() string {
 	return e.err.Error() + " warp by " + e.msg
 }
 
+// Message 本层附加的信息
+func (e *WarpError) Message() string {
+	return e.msg
+}
+
 func (e *WarpError) Unwrap() error {
 	return e.err
 }
@@ -1,8 +1,11 @@
 module github.com/xsean2020/misc
 
-go 1.18
+go 1.20
 
 require (
 	github.com/panjf2000/ants/v2 v2.7.3
+	go.uber.org/zap v1.27.0
 	golang.org/x/exp v0.0.0-20240119083558-1b970713d09a
 )
+
+require go.uber.org/multierr v1.10.0 // indirect
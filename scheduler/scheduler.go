@@ -0,0 +1,212 @@
+// Package scheduler 统一 time 标准库, time2.Wheel 和 timingwheel.TimingWheel 的接口
+// 单独成包, 避免 timingwheel 依赖 time2 (time2 初始化时会启动默认时间轮)
+package scheduler
+
+import (
+	"errors"
+	"time"
+
+	"github.com/xsean2020/misc/time2"
+	"github.com/xsean2020/misc/timingwheel"
+)
+
+// 可选的 Scheduler 实现
+const (
+	BackendStd         = "std"
+	BackendTime2       = "time2"
+	BackendTimingWheel = "timingwheel"
+)
+
+// Timer Scheduler.AfterFunc 返回的定时器
+type Timer interface {
+	Stop()
+}
+
+// Ticker Scheduler.NewTicker 返回的周期定时器
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// Scheduler 统一 time 标准库, time2.Wheel 和 TimingWheel 的接口
+type Scheduler interface {
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+	NewTicker(d time.Duration) Ticker
+	Stop()
+}
+
+// New 按名称创建 Scheduler, 用于通过配置选择实现
+// tick 为时间轮的精度, buckets 只对 timingwheel 有效
+func New(backend string, tick time.Duration, buckets int) (Scheduler, error) {
+	switch backend {
+	case BackendStd:
+		return Std(), nil
+	case BackendTime2:
+		return FromWheel(time2.NewWheel(tick)), nil
+	case BackendTimingWheel:
+		return FromTimingWheel(timingwheel.New(tick, buckets)), nil
+	}
+	return nil, errors.New("unknown scheduler backend " + backend)
+}
+
+// Std 基于 time 标准库
+func Std() Scheduler {
+	return stdScheduler{}
+}
+
+type stdScheduler struct{}
+
+type stdTimer struct {
+	*time.Timer
+}
+
+func (t stdTimer) Stop() {
+	t.Timer.Stop()
+}
+
+type stdTicker struct {
+	*time.Ticker
+}
+
+func (t stdTicker) Chan() <-chan time.Time {
+	return t.C
+}
+
+func (stdScheduler) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (stdScheduler) AfterFunc(d time.Duration, f func()) Timer {
+	return stdTimer{time.AfterFunc(d, f)}
+}
+
+func (stdScheduler) NewTicker(d time.Duration) Ticker {
+	return stdTicker{time.NewTicker(d)}
+}
+
+func (stdScheduler) Stop() {}
+
+// FromWheel 基于 time2.Wheel
+func FromWheel(w *time2.Wheel) Scheduler {
+	return wheelScheduler{w}
+}
+
+type wheelScheduler struct {
+	*time2.Wheel
+}
+
+type wheelTicker struct {
+	*time2.Ticker
+}
+
+func (t wheelTicker) Chan() <-chan time.Time {
+	return t.C
+}
+
+func (s wheelScheduler) AfterFunc(d time.Duration, f func()) Timer {
+	return s.Wheel.AfterFunc(d, f)
+}
+
+func (s wheelScheduler) NewTicker(d time.Duration) Ticker {
+	return wheelTicker{s.Wheel.NewTicker(d, d)}
+}
+
+// FromTimingWheel 基于 timingwheel.TimingWheel
+// 由于共享的 channel 只能关闭, 每个 After/AfterFunc/Ticker 需要一个协程转换, 时间轮停止后协程退出
+func FromTimingWheel(w *timingwheel.TimingWheel) Scheduler {
+	return sharedScheduler{w}
+}
+
+type sharedScheduler struct {
+	w *timingwheel.TimingWheel
+}
+
+// sharedTimer 等待期间占用一个协程
+type sharedTimer struct {
+	stop chan struct{}
+	once chan struct{}
+}
+
+func newSharedTimer() *sharedTimer {
+	t := &sharedTimer{
+		stop: make(chan struct{}),
+		once: make(chan struct{}, 1),
+	}
+	t.once <- struct{}{}
+	return t
+}
+
+func (t *sharedTimer) Stop() {
+	select {
+	case <-t.once:
+		close(t.stop)
+	default:
+	}
+}
+
+type sharedTicker struct {
+	*sharedTimer
+	c chan time.Time
+}
+
+func (t sharedTicker) Chan() <-chan time.Time {
+	return t.c
+}
+
+func (s sharedScheduler) After(d time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	done, cancel := s.w.Register(d)
+	go func() {
+		select {
+		case <-done:
+			c <- time.Now()
+		case <-s.w.Stopped():
+			cancel()
+		}
+	}()
+	return c
+}
+
+func (s sharedScheduler) AfterFunc(d time.Duration, f func()) Timer {
+	t := newSharedTimer()
+	done, cancel := s.w.Register(d)
+	go func() {
+		select {
+		case <-done:
+			f()
+		case <-t.stop:
+			cancel()
+		case <-s.w.Stopped():
+			cancel()
+		}
+	}()
+	return t
+}
+
+func (s sharedScheduler) NewTicker(d time.Duration) Ticker {
+	t := sharedTicker{newSharedTimer(), make(chan time.Time, 1)}
+	go func() {
+		for {
+			done, cancel := s.w.Register(d)
+			select {
+			case <-done:
+				select {
+				case t.c <- time.Now():
+				default:
+				}
+			case <-t.stop:
+				cancel()
+				return
+			case <-s.w.Stopped():
+				cancel()
+				return
+			}
+		}
+	}()
+	return t
+}
+
+func (s sharedScheduler) Stop() {
+	s.w.Stop()
+}
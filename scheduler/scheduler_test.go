@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+var backends = []string{BackendStd, BackendTime2, BackendTimingWheel}
+
+func TestScheduler(t *testing.T) {
+	for _, backend := range backends {
+		s, err := New(backend, 10*time.Millisecond, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		<-s.After(50 * time.Millisecond)
+		if elapsed := time.Since(start); elapsed < 30*time.Millisecond || elapsed > 200*time.Millisecond {
+			t.Errorf("%s: After(50ms) fired after %v", backend, elapsed)
+		}
+
+		fired := make(chan struct{})
+		s.AfterFunc(20*time.Millisecond, func() { close(fired) })
+		select {
+		case <-fired:
+		case <-time.After(time.Second):
+			t.Errorf("%s: AfterFunc not fired", backend)
+		}
+
+		stopped := make(chan struct{})
+		s.AfterFunc(50*time.Millisecond, func() { close(stopped) }).Stop()
+
+		ticker := s.NewTicker(20 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			select {
+			case <-ticker.Chan():
+			case <-time.After(time.Second):
+				t.Fatalf("%s: ticker not fired", backend)
+			}
+		}
+		ticker.Stop()
+
+		select {
+		case <-stopped:
+			t.Errorf("%s: stopped AfterFunc fired", backend)
+		case <-time.After(100 * time.Millisecond):
+		}
+		s.Stop()
+	}
+
+	if _, err := New("unknown", time.Millisecond, 1); err == nil {
+		t.Error("unknown backend should fail")
+	}
+}
+
+// 时间轮停止后等待中的协程应退出
+func TestTimingWheelStop(t *testing.T) {
+	before := runtime.NumGoroutine()
+	s, err := New(BackendTimingWheel, 10*time.Millisecond, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		s.After(time.Hour)
+		s.AfterFunc(time.Hour, func() {})
+		s.NewTicker(time.Hour)
+	}
+	s.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines leaked after Stop", n-before)
+	}
+}
+
+func BenchmarkSchedulerAfter(b *testing.B) {
+	for _, backend := range backends {
+		s, _ := New(backend, time.Millisecond, 1024)
+		b.Run(backend, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s.After(time.Second)
+			}
+		})
+		s.Stop()
+	}
+}
+
+func BenchmarkSchedulerAfterFunc(b *testing.B) {
+	for _, backend := range backends {
+		s, _ := New(backend, time.Millisecond, 1024)
+		b.Run(backend, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s.AfterFunc(time.Second, func() {}).Stop()
+			}
+		})
+		s.Stop()
+	}
+}
+
+func BenchmarkSchedulerFire(b *testing.B) {
+	for _, backend := range backends {
+		s, _ := New(backend, time.Millisecond, 1024)
+		b.Run(backend, func(b *testing.B) {
+			b.ReportAllocs()
+			var wg sync.WaitGroup
+			wg.Add(b.N)
+			for i := 0; i < b.N; i++ {
+				s.AfterFunc(10*time.Millisecond, wg.Done)
+			}
+			wg.Wait()
+		})
+		s.Stop()
+	}
+}
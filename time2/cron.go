@@ -0,0 +1,441 @@
+// 表达式解析与 Next 的计算移植自 github.com/robfig/cron (v3 parser.go, spec.go)
+//
+// Copyright (C) 2012 Rob Figueiredo
+// All Rights Reserved.
+//
+// MIT LICENSE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package time2
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cron 字段的取值范围
+type bounds struct {
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	seconds = bounds{0, 59, nil}
+	minutes = bounds{0, 59, nil}
+	hours   = bounds{0, 23, nil}
+	doms    = bounds{1, 31, nil}
+	months  = bounds{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dows = bounds{0, 7, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// 字段为 * 或 ? 时的标记位
+const starBit = 1 << 63
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// Cron 解析后的 cron 表达式
+// 支持 5 个字段(分 时 日 月 周) 或 6 个字段(秒 分 时 日 月 周)
+// 可以用 CRON_TZ=Asia/Shanghai 前缀指定时区, 默认 time.Local
+type Cron struct {
+	spec                                  string
+	second, minute, hour, dom, month, dow uint64
+	loc                                   *time.Location
+}
+
+func ParseCron(spec string) (*Cron, error) {
+	c := &Cron{spec: spec, loc: time.Local}
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.IndexByte(spec, ' ')
+		if i < 0 {
+			return nil, errors.New("cron: missing fields after time zone in " + strconv.Quote(c.spec))
+		}
+		loc, err := time.LoadLocation(spec[strings.IndexByte(spec, '=')+1 : i])
+		if err != nil {
+			return nil, err
+		}
+		c.loc = loc
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	if d, ok := descriptors[spec]; ok {
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, errors.New("cron: expected 5 or 6 fields, found " + strconv.Itoa(len(fields)) + " in " + strconv.Quote(c.spec))
+	}
+
+	var err error
+	for i, p := range []struct {
+		v *uint64
+		b bounds
+	}{
+		{&c.second, seconds},
+		{&c.minute, minutes},
+		{&c.hour, hours},
+		{&c.dom, doms},
+		{&c.month, months},
+		{&c.dow, dows},
+	} {
+		if *p.v, err = parseField(fields[i], p.b); err != nil {
+			return nil, err
+		}
+	}
+	if c.dow&(1<<7) > 0 { // 7 也表示周日
+		c.dow = c.dow&^(1<<7) | 1
+	}
+	return c, nil
+}
+
+// parseField 解析 1,2,3 / 1-5 / */2 / 10-20/5 等形式
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, expr := range strings.Split(field, ",") {
+		rangeAndStep := strings.Split(expr, "/")
+		lowAndHigh := strings.Split(rangeAndStep[0], "-")
+		if len(rangeAndStep) > 2 || len(lowAndHigh) > 2 {
+			return 0, errors.New("cron: invalid expression " + strconv.Quote(expr))
+		}
+
+		var (
+			start, end, step uint = 0, 0, 1
+			err              error
+		)
+		if lowAndHigh[0] == "*" || lowAndHigh[0] == "?" {
+			if len(lowAndHigh) > 1 {
+				return 0, errors.New("cron: invalid expression " + strconv.Quote(expr))
+			}
+			start, end = b.min, b.max
+			if len(rangeAndStep) == 1 {
+				bits |= starBit
+			}
+		} else {
+			if start, err = parseValue(lowAndHigh[0], b); err != nil {
+				return 0, err
+			}
+			end = start
+			if len(lowAndHigh) == 2 {
+				if end, err = parseValue(lowAndHigh[1], b); err != nil {
+					return 0, err
+				}
+			} else if len(rangeAndStep) == 2 { // 5/10 表示 5-max/10
+				end = b.max
+			}
+		}
+
+		if len(rangeAndStep) == 2 {
+			n, err := strconv.ParseUint(rangeAndStep[1], 10, 32)
+			if err != nil || n == 0 {
+				return 0, errors.New("cron: invalid step in " + strconv.Quote(expr))
+			}
+			step = uint(n)
+		}
+
+		if start > end {
+			return 0, errors.New("cron: beginning of range after end in " + strconv.Quote(expr))
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << i
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(s string, b bounds) (uint, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, errors.New("cron: invalid value " + strconv.Quote(s))
+	}
+	if uint(n) < b.min || uint(n) > b.max {
+		return 0, errors.New("cron: value " + s + " out of range [" +
+			strconv.Itoa(int(b.min)) + ", " + strconv.Itoa(int(b.max)) + "]")
+	}
+	return uint(n), nil
+}
+
+func (c *Cron) String() string {
+	return c.spec
+}
+
+// Location 表达式使用的时区
+func (c *Cron) Location() *time.Location {
+	return c.loc
+}
+
+// In 返回使用 loc 时区计算的副本
+func (c *Cron) In(loc *time.Location) *Cron {
+	cc := *c
+	cc.loc = loc
+	return &cc
+}
+
+// dayMatches 日和周都不是 * 时满足其一即可
+func (c *Cron) dayMatches(t time.Time) bool {
+	domMatch := 1<<uint(t.Day())&c.dom > 0
+	dowMatch := 1<<uint(t.Weekday())&c.dow > 0
+	if c.dom&starBit > 0 || c.dow&starBit > 0 {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next 返回 t 之后的下一个触发时间, 5 年内没有则返回零值
+func (c *Cron) Next(t time.Time) time.Time {
+	origLoc := t.Location()
+	t = t.In(c.loc)
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+
+	// 找到匹配的时间前, 每次进位都要把更低的字段清零
+	added := false
+	yearLimit := t.Year() + 5
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for 1<<uint(t.Month())&c.month == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, c.loc)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+
+	for !c.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.loc)
+		}
+		t = t.AddDate(0, 0, 1)
+		// 夏令时切换时 0 点可能不存在
+		if t.Hour() != 0 {
+			if t.Hour() > 12 {
+				t = t.Add(time.Duration(24-t.Hour()) * time.Hour)
+			} else {
+				t = t.Add(time.Duration(-t.Hour()) * time.Hour)
+			}
+		}
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+
+	for 1<<uint(t.Hour())&c.hour == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, c.loc)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+
+	for 1<<uint(t.Minute())&c.minute == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+
+	for 1<<uint(t.Second())&c.second == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Second)
+		}
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto WRAP
+		}
+	}
+
+	return t.In(origLoc)
+}
+
+// CronEntry 注册到 Scheduler 上的任务
+type CronEntry struct {
+	ID   int64
+	Cron *Cron
+
+	s       *Scheduler
+	f       func()
+	mu      sync.Mutex
+	next    time.Time
+	timer   *Timer
+	stopped bool
+}
+
+// Next 下一次触发时间, 已停止返回零值
+func (e *CronEntry) Next() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopped {
+		return time.Time{}
+	}
+	return e.next
+}
+
+func (e *CronEntry) Stop() {
+	e.mu.Lock()
+	e.unsafeStop()
+	e.mu.Unlock()
+}
+
+func (e *CronEntry) unsafeStop() {
+	if e.stopped {
+		return
+	}
+	e.stopped = true
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	e.s.remove(e.ID)
+}
+
+// arm 计算 from 之后的触发时间并注册到时间轮
+func (e *CronEntry) arm(from time.Time) {
+	e.next = e.Cron.Next(from)
+	if e.next.IsZero() {
+		e.unsafeStop()
+		return
+	}
+	e.timer = e.s.w.AfterFunc(time.Until(e.next), e.run)
+}
+
+func (e *CronEntry) run() {
+	e.mu.Lock()
+	if e.stopped {
+		e.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	if now.Before(e.next) { // 时间轮按 tick 取整可能提前触发
+		e.timer = e.s.w.AfterFunc(e.next.Sub(now), e.run)
+		e.mu.Unlock()
+		return
+	}
+
+	e.arm(e.next)
+	e.mu.Unlock()
+	e.f()
+}
+
+// Scheduler 在 Wheel 上按 cron 表达式调度任务
+type Scheduler struct {
+	w       *Wheel
+	mu      sync.Mutex
+	seq     int64
+	entries map[int64]*CronEntry
+}
+
+// w 为 nil 时使用默认的时间轮
+func NewScheduler(w *Wheel) *Scheduler {
+	if w == nil {
+		w = defaultWheel
+	}
+	return &Scheduler{
+		w:       w,
+		entries: make(map[int64]*CronEntry),
+	}
+}
+
+// Schedule 解析 spec 并注册任务
+func (s *Scheduler) Schedule(spec string, f func()) (*CronEntry, error) {
+	c, err := ParseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+	return s.Add(c, f), nil
+}
+
+func (s *Scheduler) Add(c *Cron, f func()) *CronEntry {
+	e := &CronEntry{Cron: c, s: s, f: f}
+	s.mu.Lock()
+	s.seq++
+	e.ID = s.seq
+	s.entries[e.ID] = e
+	s.mu.Unlock()
+
+	e.mu.Lock()
+	e.arm(time.Now())
+	e.mu.Unlock()
+	return e
+}
+
+// Entries 按注册顺序返回所有未停止的任务
+func (s *Scheduler) Entries() []*CronEntry {
+	s.mu.Lock()
+	entries := make([]*CronEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	s.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+// Stop 停止所有任务
+func (s *Scheduler) Stop() {
+	for _, e := range s.Entries() {
+		e.Stop()
+	}
+}
+
+func (s *Scheduler) remove(id int64) {
+	s.mu.Lock()
+	delete(s.entries, id)
+	s.mu.Unlock()
+}
@@ -0,0 +1,124 @@
+package time2
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseCronError(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"CRON_TZ=Nowhere/City * * * * *",
+	} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) should fail", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	cases := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"* * * * * *", "2024-01-01T00:00:00Z", "2024-01-01T00:00:01Z"},
+		{"*/15 * * * *", "2024-01-01T00:07:30Z", "2024-01-01T00:15:00Z"},
+		{"0 0 * * *", "2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z"},
+		{"@daily", "2024-02-28T12:00:00Z", "2024-02-29T00:00:00Z"},
+		{"30 4 1,15 * *", "2024-01-02T00:00:00Z", "2024-01-15T04:30:00Z"},
+		{"0 0 * * mon", "2024-01-01T00:00:00Z", "2024-01-08T00:00:00Z"},
+		{"0 0 * * 7", "2024-01-01T00:00:00Z", "2024-01-07T00:00:00Z"},
+		{"0 0 13 * 5", "2024-01-01T00:00:00Z", "2024-01-05T00:00:00Z"},
+		{"0 0 29 2 *", "2024-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"10-20/5 0 0 1 jan *", "2024-01-01T00:00:12Z", "2024-01-01T00:00:15Z"},
+		{"0 0 31 2 *", "2024-01-01T00:00:00Z", ""},
+		{"CRON_TZ=Asia/Shanghai 0 0 * * *", "2024-01-01T00:00:00Z", "2024-01-01T16:00:00Z"},
+	}
+	for _, c := range cases {
+		cron, err := ParseCron(c.spec)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", c.spec, err)
+		}
+		if c.spec[0] != 'C' {
+			cron = cron.In(time.UTC)
+		}
+		from, _ := time.Parse(time.RFC3339, c.from)
+		next := cron.Next(from)
+		if c.want == "" {
+			if !next.IsZero() {
+				t.Errorf("%q.Next(%s) = %s, want zero", c.spec, c.from, next)
+			}
+			continue
+		}
+		want, _ := time.Parse(time.RFC3339, c.want)
+		if !next.Equal(want) {
+			t.Errorf("%q.Next(%s) = %s, want %s", c.spec, c.from, next, want)
+		}
+	}
+
+	cron, _ := ParseCron("0 8 * * *")
+	next := cron.In(shanghai).Next(time.Date(2024, 1, 1, 9, 0, 0, 0, shanghai))
+	if want := time.Date(2024, 1, 2, 8, 0, 0, 0, shanghai); !next.Equal(want) {
+		t.Errorf("Next = %s, want %s", next, want)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	w := NewWheel(10 * time.Millisecond)
+	defer w.Stop()
+	s := NewScheduler(w)
+
+	var count int32
+	done := make(chan struct{})
+	e, err := s.Schedule("* * * * * *", func() {
+		if atomic.AddInt32(&count, 1) == 2 {
+			close(done)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := s.Entries(); len(entries) != 1 || entries[0] != e {
+		t.Fatalf("Entries() = %v", entries)
+	}
+
+	next := e.Next()
+	if next.Nanosecond() != 0 || time.Until(next) > time.Second {
+		t.Errorf("Next() = %s", next)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("cron job was not triggered")
+	}
+	if now := time.Now(); now.Before(next.Add(time.Second)) {
+		t.Errorf("second run at %s before %s", now, next.Add(time.Second))
+	}
+
+	e.Stop()
+	if entries := s.Entries(); len(entries) != 0 {
+		t.Errorf("Entries() after Stop = %v", entries)
+	}
+	n := atomic.LoadInt32(&count)
+	time.Sleep(1100 * time.Millisecond)
+	if atomic.LoadInt32(&count) != n {
+		t.Error("cron job triggered after Stop")
+	}
+}
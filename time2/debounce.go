@@ -0,0 +1,182 @@
+package time2
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xsean2020/misc"
+)
+
+type debounceConfig struct {
+	leading  bool
+	trailing bool
+	maxWait  time.Duration
+}
+
+type DebounceOption func(c *debounceConfig)
+
+// 一轮调用的第一次立即执行, 默认 false
+func WithLeading(b bool) DebounceOption {
+	return func(c *debounceConfig) {
+		c.leading = b
+	}
+}
+
+// 停止调用 wait 之后执行, 默认 true
+func WithTrailing(b bool) DebounceOption {
+	return func(c *debounceConfig) {
+		c.trailing = b
+	}
+}
+
+// 持续调用时最多等待 d 就执行一次
+func WithMaxWait(d time.Duration) DebounceOption {
+	return func(c *debounceConfig) {
+		c.maxWait = d
+	}
+}
+
+// Debouncer 基于时间轮的防抖/节流, 整个生命周期只占用一个定时器
+// f 通过时间轮的协程池执行
+type Debouncer struct {
+	w    *Wheel
+	wait time.Duration
+	f    func()
+	cfg  debounceConfig
+
+	mu       sync.Mutex
+	timer    *Timer
+	active   bool      // 一轮调用进行中
+	pending  bool      // 有尚未执行的调用
+	start    time.Time // 本轮或上次执行的时间, 用于 maxWait
+	lastCall time.Time
+}
+
+func Debounce(wait time.Duration, f func(), opts ...DebounceOption) *Debouncer {
+	return defaultWheel.Debounce(wait, f, opts...)
+}
+
+func Throttle(wait time.Duration, f func(), opts ...DebounceOption) *Debouncer {
+	return defaultWheel.Throttle(wait, f, opts...)
+}
+
+// Debounce 停止调用 wait 之后执行 f
+func (w *Wheel) Debounce(wait time.Duration, f func(), opts ...DebounceOption) *Debouncer {
+	d := &Debouncer{
+		w:    w,
+		wait: wait,
+		f:    f,
+		cfg:  debounceConfig{trailing: true},
+	}
+	for _, opt := range opts {
+		opt(&d.cfg)
+	}
+	return d
+}
+
+// Throttle 每 wait 最多执行一次 f, 等同于 leading 且 maxWait 为 wait 的防抖
+func (w *Wheel) Throttle(wait time.Duration, f func(), opts ...DebounceOption) *Debouncer {
+	opts = append([]DebounceOption{WithLeading(true), WithMaxWait(wait)}, opts...)
+	return w.Debounce(wait, f, opts...)
+}
+
+func (d *Debouncer) Call() {
+	now := d.w.Now()
+	var run bool
+	d.mu.Lock()
+	d.lastCall = now
+	if d.active {
+		d.pending = true
+		d.mu.Unlock()
+		return
+	}
+
+	d.active = true
+	d.start = now
+	if d.cfg.leading {
+		run = true
+	} else {
+		d.pending = true
+	}
+	d.arm(d.wait)
+	d.mu.Unlock()
+	d.invoke(run)
+}
+
+// Flush 立即执行尚未执行的调用
+func (d *Debouncer) Flush() {
+	d.mu.Lock()
+	run := d.pending
+	d.unsafeStop()
+	d.mu.Unlock()
+	d.invoke(run)
+}
+
+// Stop 取消尚未执行的调用
+func (d *Debouncer) Stop() {
+	d.mu.Lock()
+	d.unsafeStop()
+	d.mu.Unlock()
+}
+
+func (d *Debouncer) unsafeStop() {
+	d.active = false
+	d.pending = false
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
+
+// invoke 在释放锁之后提交 f, OverloadInline 下 f 可能直接执行, 其中可以再调用 Call 或 Flush
+func (d *Debouncer) invoke(run bool) {
+	if run {
+		d.w.cfg.Submit(d.f)
+	}
+}
+
+// arm 需要持有锁
+func (d *Debouncer) arm(wait time.Duration) {
+	if d.cfg.maxWait > 0 {
+		wait = misc.Min(wait, d.cfg.maxWait)
+	}
+	if d.timer == nil {
+		d.timer = d.w.AfterFunc(wait, d.onTimer)
+	} else {
+		d.timer.Reset(wait)
+	}
+}
+
+func (d *Debouncer) onTimer() {
+	var run bool
+	defer func() {
+		d.invoke(run)
+	}()
+
+	now := d.w.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.active {
+		return
+	}
+
+	// 已经 wait 没有调用, 结束本轮
+	quiet := now.Sub(d.lastCall)
+	if quiet >= d.wait {
+		run = d.cfg.trailing && d.pending
+		d.active = false
+		d.pending = false
+		return
+	}
+
+	if d.cfg.maxWait > 0 && now.Sub(d.start) >= d.cfg.maxWait {
+		run = d.pending
+		d.pending = false
+		d.start = now
+	}
+
+	remain := d.wait - quiet
+	if d.cfg.maxWait > 0 {
+		remain = misc.Min(remain, d.cfg.maxWait-now.Sub(d.start))
+	}
+	d.timer.Reset(remain)
+}
@@ -0,0 +1,118 @@
+package time2
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/ants/v2"
+)
+
+// burst 每隔 interval 调用一次, 持续 d
+func burst(d *Debouncer, interval, duration time.Duration) {
+	for end := time.Now().Add(duration); time.Now().Before(end); {
+		d.Call()
+		time.Sleep(interval)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	w := NewWheel(5 * time.Millisecond)
+	defer w.Stop()
+
+	cases := []struct {
+		name     string
+		throttle bool
+		opts     []DebounceOption
+		min, max int32
+	}{
+		{"trailing", false, nil, 1, 1},
+		{"leading", false, []DebounceOption{WithLeading(true), WithTrailing(false)}, 1, 1},
+		{"leading and trailing", false, []DebounceOption{WithLeading(true)}, 2, 2},
+		{"max wait", false, []DebounceOption{WithMaxWait(100 * time.Millisecond)}, 3, 4},
+		{"throttle", true, nil, 4, 6},
+	}
+	for _, c := range cases {
+		var count int32
+		f := func() {
+			atomic.AddInt32(&count, 1)
+		}
+		var d *Debouncer
+		if c.throttle {
+			d = w.Throttle(100*time.Millisecond, f, c.opts...)
+		} else {
+			d = w.Debounce(50*time.Millisecond, f, c.opts...)
+		}
+
+		burst(d, 5*time.Millisecond, 350*time.Millisecond)
+		time.Sleep(200 * time.Millisecond)
+		if n := atomic.LoadInt32(&count); n < c.min || n > c.max {
+			t.Errorf("%s: called %d times, want [%d, %d]", c.name, n, c.min, c.max)
+		}
+	}
+}
+
+func TestDebounceFlushStop(t *testing.T) {
+	w := NewWheel(5 * time.Millisecond)
+	defer w.Stop()
+
+	var count int32
+	d := w.Debounce(50*time.Millisecond, func() {
+		atomic.AddInt32(&count, 1)
+	})
+
+	d.Call()
+	d.Flush()
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&count); n != 1 {
+		t.Errorf("called %d times after Flush, want 1", n)
+	}
+
+	d.Call()
+	d.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&count); n != 1 {
+		t.Errorf("called %d times after Stop, want 1", n)
+	}
+
+	// Stop 之后可以继续使用
+	d.Call()
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&count); n != 2 {
+		t.Errorf("called %d times, want 2", n)
+	}
+}
+
+// OverloadInline 下 f 在提交的协程中执行, 其中再调用 Call/Flush 不应死锁
+func TestDebounceReentrant(t *testing.T) {
+	pool, err := ants.NewPool(1, ants.WithNonblocking(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Release()
+	w := NewWheel(5*time.Millisecond, WithGoroutinePool(pool), WithOverload(OverloadInline))
+	defer w.Stop()
+
+	release := make(chan struct{})
+	defer close(release)
+	if err := pool.Submit(func() { <-release }); err != nil {
+		t.Fatal(err)
+	}
+
+	var d *Debouncer
+	var calls int32
+	done := make(chan struct{})
+	d = w.Debounce(20*time.Millisecond, func() {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			d.Call()
+			d.Flush()
+			close(done)
+		}
+	}, WithLeading(true))
+	go d.Call()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("debouncer deadlocked when f calls Call/Flush")
+	}
+}
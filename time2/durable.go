@@ -0,0 +1,246 @@
+package time2
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DurableRecord 需要持久化的定时器
+type DurableRecord struct {
+	ID       string    `json:"id"`
+	Deadline time.Time `json:"deadline"`
+	Payload  []byte    `json:"payload,omitempty"`
+}
+
+// Store 持久化定时器的存储
+type Store interface {
+	Save(rec DurableRecord) error
+	Delete(id string) error
+	Load() ([]DurableRecord, error)
+}
+
+// FileStore 把所有记录以 JSON 保存在一个文件中, 每次修改整体重写
+type FileStore struct {
+	path    string
+	mu      sync.Mutex
+	records map[string]DurableRecord
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Load() ([]DurableRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s.list(), nil
+}
+
+func (s *FileStore) Save(rec DurableRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.records[rec.ID] = rec
+	return s.flush()
+}
+
+func (s *FileStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	if _, ok := s.records[id]; !ok {
+		return nil
+	}
+	delete(s.records, id)
+	return s.flush()
+}
+
+// load 首次使用时从文件读取
+func (s *FileStore) load() error {
+	if s.records != nil {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var list []DurableRecord
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+	}
+	s.records = make(map[string]DurableRecord, len(list))
+	for _, rec := range list {
+		s.records[rec.ID] = rec
+	}
+	return nil
+}
+
+func (s *FileStore) list() []DurableRecord {
+	list := make([]DurableRecord, 0, len(s.records))
+	for _, rec := range s.records {
+		list = append(list, rec)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Deadline.Before(list[j].Deadline)
+	})
+	return list
+}
+
+// flush 先写临时文件再改名, 避免写到一半进程退出导致文件损坏
+func (s *FileStore) flush() error {
+	data, err := json.Marshal(s.list())
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// DurableHandler 定时器到期时调用, late 为 true 表示重新加载时已经过期
+type DurableHandler func(rec DurableRecord, late bool)
+
+type durableEntry struct {
+	rec   DurableRecord
+	late  bool
+	timer *Timer
+}
+
+// DurableTimers 在 Wheel 上运行, 同时记录到 Store 的定时器, 进程重启后可以恢复
+// 回调执行完才从 Store 删除, 重启前未执行完的定时器会再次触发
+type DurableTimers struct {
+	w       *Wheel
+	store   Store
+	handler DurableHandler
+
+	mu     sync.Mutex
+	timers map[string]*durableEntry
+}
+
+// NewDurableTimers 从 store 加载并恢复定时器, w 为 nil 时使用默认的时间轮
+func NewDurableTimers(w *Wheel, store Store, handler DurableHandler) (*DurableTimers, error) {
+	if w == nil {
+		w = defaultWheel
+	}
+	records, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DurableTimers{
+		w:       w,
+		store:   store,
+		handler: handler,
+		timers:  make(map[string]*durableEntry, len(records)),
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	for _, rec := range records {
+		d.arm(rec, !now.Before(rec.Deadline))
+	}
+	d.mu.Unlock()
+	return d, nil
+}
+
+// Schedule 新增或替换 id 对应的定时器
+func (d *DurableTimers) Schedule(id string, deadline time.Time, payload []byte) error {
+	rec := DurableRecord{ID: id, Deadline: deadline, Payload: payload}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.store.Save(rec); err != nil {
+		return err
+	}
+	d.arm(rec, false)
+	return nil
+}
+
+// Cancel 取消定时器并从 Store 删除
+func (d *DurableTimers) Cancel(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.timers[id]; ok {
+		e.timer.Stop()
+		delete(d.timers, id)
+	}
+	return d.store.Delete(id)
+}
+
+// Deadline 返回 id 对应定时器的到期时间
+func (d *DurableTimers) Deadline(id string) (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.timers[id]; ok {
+		return e.rec.Deadline, true
+	}
+	return time.Time{}, false
+}
+
+// Stop 停止所有定时器, 保留 Store 中的记录, 用于进程退出
+func (d *DurableTimers) Stop() {
+	d.mu.Lock()
+	for id, e := range d.timers {
+		e.timer.Stop()
+		delete(d.timers, id)
+	}
+	d.mu.Unlock()
+}
+
+// arm 注册到时间轮, 需要持有锁
+func (d *DurableTimers) arm(rec DurableRecord, late bool) {
+	if e, ok := d.timers[rec.ID]; ok {
+		e.timer.Stop()
+	}
+	e := &durableEntry{rec: rec, late: late}
+	d.wait(e)
+	d.timers[rec.ID] = e
+}
+
+func (d *DurableTimers) wait(e *durableEntry) {
+	e.timer = d.w.AfterFunc(time.Until(e.rec.Deadline), func() {
+		d.fire(e)
+	})
+}
+
+func (d *DurableTimers) fire(e *durableEntry) {
+	id := e.rec.ID
+	d.mu.Lock()
+	if d.timers[id] != e { // 已经被取消或替换
+		d.mu.Unlock()
+		return
+	}
+	if time.Now().Before(e.rec.Deadline) { // 时间轮按 tick 取整可能提前触发
+		d.wait(e)
+		d.mu.Unlock()
+		return
+	}
+	delete(d.timers, id)
+	d.mu.Unlock()
+
+	d.handler(e.rec, e.late)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.timers[id]; ok { // 回调中重新注册了
+		return
+	}
+	if err := d.store.Delete(id); err != nil {
+		log.Println("delete durable timer", id, "failed:", err)
+	}
+}
@@ -0,0 +1,84 @@
+package time2
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fired struct {
+	rec  DurableRecord
+	late bool
+}
+
+func TestDurableTimers(t *testing.T) {
+	w := NewWheel(10 * time.Millisecond)
+	defer w.Stop()
+
+	path := filepath.Join(t.TempDir(), "timers.json")
+	c := make(chan fired, 10)
+	handler := func(rec DurableRecord, late bool) {
+		c <- fired{rec, late}
+	}
+
+	d, err := NewDurableTimers(w, NewFileStore(path), handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for id, after := range map[string]time.Duration{
+		"overdue":   100 * time.Millisecond,
+		"future":    time.Second,
+		"cancelled": time.Second,
+	} {
+		if err := d.Schedule(id, now.Add(after), []byte(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Cancel("cancelled"); err != nil {
+		t.Fatal(err)
+	}
+	if deadline, ok := d.Deadline("future"); !ok || !deadline.Equal(now.Add(time.Second)) {
+		t.Errorf("Deadline(future) = %v, %v", deadline, ok)
+	}
+
+	// 模拟进程退出, 重启前 overdue 已经过期
+	d.Stop()
+	time.Sleep(200 * time.Millisecond)
+
+	records, err := NewFileStore(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("store has %d records, want 2", len(records))
+	}
+
+	d, err = NewDurableTimers(w, NewFileStore(path), handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+
+	for _, want := range []fired{
+		{DurableRecord{ID: "overdue", Payload: []byte("overdue")}, true},
+		{DurableRecord{ID: "future", Payload: []byte("future")}, false},
+	} {
+		select {
+		case f := <-c:
+			if f.rec.ID != want.rec.ID || string(f.rec.Payload) != string(want.rec.Payload) || f.late != want.late {
+				t.Errorf("fired %+v, want %+v", f, want)
+			}
+			if time.Now().Before(f.rec.Deadline) {
+				t.Errorf("%s fired before deadline", f.rec.ID)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s was not triggered", want.rec.ID)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if records, _ := NewFileStore(path).Load(); len(records) != 0 {
+		t.Errorf("store has %d records after fired", len(records))
+	}
+}
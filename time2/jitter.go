@@ -0,0 +1,216 @@
+package time2
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xsean2020/misc/rand"
+)
+
+func newRand() rand.Value {
+	return rand.NewLockedLCG(uint32(time.Now().UnixNano()))
+}
+
+// jitter 返回 [-span, span] 内按 tick 对齐的随机偏移
+// span 为 tick 的整数倍时以 tick 为单位取随机数, 避免超出 rand.Value 的取值范围
+// 否则在 [-span, span] 内取随机数后随机取整到相邻的 tick, 概率与余数成正比,
+// span 小于 tick 时偏移的期望仍与 span 成正比
+func jitter(r rand.Value, span, tick time.Duration) time.Duration {
+	if span <= 0 {
+		return 0
+	}
+	if span%tick == 0 {
+		n := int64(span / tick)
+		return time.Duration(rand.Between(r, -n, n)) * tick
+	}
+
+	// rand.Value 只有 31 位, 取随机数的精度随 span 放大
+	unit := span>>30 + 1
+	n := int64(span / unit)
+	u := time.Duration(rand.Between(r, -n, n)) * unit
+	q, rem := u/tick, u%tick
+	if rem < 0 {
+		q, rem = q-1, rem+tick
+	}
+	if rem > 0 && float64(rand.Between[int64](r, 0, 1<<30-1))/(1<<30) < float64(rem)/float64(tick) {
+		q++
+	}
+	return q * tick
+}
+
+// JitterTicker 周期在 d*(1-factor) ~ d*(1+factor) 之间随机变化的 ticker
+// 用于错开多个实例的定时任务
+type JitterTicker struct {
+	C <-chan time.Time
+	r *timer
+
+	mu      sync.Mutex
+	period  time.Duration
+	factor  float64
+	rnd     rand.Value
+	stopped bool
+}
+
+// r 为 nil 时使用以当前时间为种子的随机源
+func NewJitterTicker(d time.Duration, factor float64, r rand.Value) *JitterTicker {
+	return defaultWheel.NewJitterTicker(d, factor, r)
+}
+
+func (w *Wheel) NewJitterTicker(d time.Duration, factor float64, r rand.Value) *JitterTicker {
+	if d < w.tick {
+		panic("period(" + d.String() + ") less than tick(" + w.tick.String() + ")")
+	}
+	if factor < 0 {
+		factor = 0
+	} else if factor > 1 {
+		factor = 1
+	}
+	if r == nil {
+		r = newRand()
+	}
+
+	c := make(chan time.Time, 1)
+	t := &JitterTicker{
+		C:      c,
+		period: d,
+		factor: factor,
+		rnd:    r,
+	}
+	t.r = w.newTimer(func(now time.Time, arg interface{}) {
+		sendTime(now, arg)
+		t.mu.Lock()
+		if !t.stopped {
+			w.schedule(t.r, t.next(), 0)
+		}
+		t.mu.Unlock()
+	}, c)
+
+	t.mu.Lock()
+	w.schedule(t.r, t.next(), 0)
+	t.mu.Unlock()
+	return t
+}
+
+// next 计算下一个周期, 需要持有锁
+func (t *JitterTicker) next() time.Duration {
+	tick := t.r.w.tick
+	d := t.period + jitter(t.rnd, time.Duration(float64(t.period)*t.factor), tick)
+	if d < tick {
+		d = tick
+	}
+	return d
+}
+
+func (t *JitterTicker) Stop() {
+	t.mu.Lock()
+	t.stopped = true
+	t.r.w.delTimer(t.r)
+	t.mu.Unlock()
+}
+
+// Reset 修改基准周期并重新计时
+func (t *JitterTicker) Reset(d time.Duration) {
+	t.mu.Lock()
+	t.period = d
+	t.stopped = false
+	t.r.w.resetTimer(t.r, t.next(), 0)
+	t.mu.Unlock()
+}
+
+func (t *JitterTicker) When() time.Time {
+	return t.r.when()
+}
+
+// BackoffTimer 指数退避定时器
+// 每次 Fail 以当前间隔启动定时器并把间隔乘以 multiplier (不超过 max), Succeed 恢复到 min
+type BackoffTimer struct {
+	C <-chan time.Time
+	r *timer
+
+	mu         sync.Mutex
+	min, max   time.Duration
+	multiplier float64
+	rnd        rand.Value
+	cur        time.Duration
+	armed      bool
+}
+
+// r 不为 nil 时实际等待时长在 [cur/2, cur] 之间随机
+func NewBackoffTimer(min, max time.Duration, multiplier float64, r rand.Value) *BackoffTimer {
+	return defaultWheel.NewBackoffTimer(min, max, multiplier, r)
+}
+
+func (w *Wheel) NewBackoffTimer(min, max time.Duration, multiplier float64, r rand.Value) *BackoffTimer {
+	if min <= 0 || max < min {
+		panic("invalid backoff range [" + min.String() + ", " + max.String() + "]")
+	}
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	c := make(chan time.Time, 1)
+	return &BackoffTimer{
+		C:          c,
+		r:          w.newTimer(sendTime, c),
+		min:        min,
+		max:        max,
+		multiplier: multiplier,
+		rnd:        r,
+		cur:        min,
+	}
+}
+
+// Fail 按当前间隔重新计时并增大下一次的间隔, 返回本次的等待时长
+func (b *BackoffTimer) Fail() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d := b.cur
+	if b.rnd != nil { // 落在 [cur/2, cur] 之间
+		tick := b.r.w.tick
+		d -= time.Duration(rand.Between(b.rnd, 0, int64(d/2/tick))) * tick
+	}
+
+	next := time.Duration(float64(b.cur) * b.multiplier)
+	if next > b.max || next < b.cur { // 溢出
+		next = b.max
+	}
+	b.cur = next
+
+	if b.armed {
+		b.r.w.resetTimer(b.r, d, 0)
+	} else {
+		b.armed = true
+		b.r.w.schedule(b.r, d, 0)
+	}
+	return d
+}
+
+// Succeed 停止定时器, 间隔恢复到 min
+func (b *BackoffTimer) Succeed() {
+	b.mu.Lock()
+	b.cur = b.min
+	b.unsafeStop()
+	b.mu.Unlock()
+}
+
+// Stop 停止定时器, 保留当前间隔
+func (b *BackoffTimer) Stop() {
+	b.mu.Lock()
+	b.unsafeStop()
+	b.mu.Unlock()
+}
+
+func (b *BackoffTimer) unsafeStop() {
+	if b.armed {
+		b.armed = false
+		b.r.w.delTimer(b.r)
+	}
+}
+
+// Current 下一次 Fail 使用的间隔
+func (b *BackoffTimer) Current() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.cur
+}
@@ -0,0 +1,107 @@
+package time2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xsean2020/misc/rand"
+)
+
+func TestJitterTicker(t *testing.T) {
+	const tick = 10 * time.Millisecond
+	w := NewWheel(tick)
+	defer w.Stop()
+
+	const period = 100 * time.Millisecond
+	ticker := w.NewJitterTicker(period, 0.5, rand.NewLCG(42))
+	defer ticker.Stop()
+
+	// 相同种子得到相同的周期序列
+	expect := rand.NewLCG(42)
+	expect.Int63() // 创建时已经消耗了一个
+	ticker.mu.Lock()
+	for i := 0; i < 100; i++ {
+		d := ticker.next()
+		if want := period + time.Duration(rand.Between[int64](expect, -5, 5))*tick; d != want {
+			t.Fatalf("next() = %v, want %v", d, want)
+		}
+		if d < period/2 || d > period*3/2 {
+			t.Fatalf("next() = %v out of range", d)
+		}
+	}
+	ticker.mu.Unlock()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ticker.C:
+		case <-time.After(time.Second):
+			t.Fatal("jitter ticker was not triggered")
+		}
+	}
+}
+
+// span 小于 tick 时按余数随机取整, 不应恒为 0
+func TestJitterSubTick(t *testing.T) {
+	const tick = 10 * time.Millisecond
+	r := rand.NewLCG(7)
+	for _, span := range []time.Duration{tick / 2, tick * 3 / 2} {
+		const n = 20000
+		var sum, abs time.Duration
+		for i := 0; i < n; i++ {
+			d := jitter(r, span, tick)
+			if d%tick != 0 || d < -span-tick || d > span+tick {
+				t.Fatalf("jitter(%v) = %v", span, d)
+			}
+			sum += d
+			if d < 0 {
+				d = -d
+			}
+			abs += d
+		}
+		// 随机取整不改变期望: E[d] = 0, E[|d|] = span/2
+		if mean := sum / n; mean < -tick/10 || mean > tick/10 {
+			t.Errorf("jitter(%v) mean = %v", span, mean)
+		}
+		if mean := abs / n; mean < span/2-tick/10 || mean > span/2+tick/10 {
+			t.Errorf("jitter(%v) mean abs = %v, want about %v", span, mean, span/2)
+		}
+	}
+}
+
+func TestBackoffTimer(t *testing.T) {
+	const tick = 10 * time.Millisecond
+	w := NewWheel(tick)
+	defer w.Stop()
+
+	b := w.NewBackoffTimer(20*time.Millisecond, 100*time.Millisecond, 2, nil)
+	for _, want := range []time.Duration{20, 40, 80, 100, 100} {
+		if d := b.Fail(); d != want*time.Millisecond {
+			t.Errorf("Fail() = %v, want %v", d, want*time.Millisecond)
+		}
+	}
+	select {
+	case <-b.C:
+	case <-time.After(time.Second):
+		t.Fatal("backoff timer was not triggered")
+	}
+
+	b.Fail()
+	b.Succeed()
+	if d := b.Current(); d != 20*time.Millisecond {
+		t.Errorf("Current() after Succeed = %v", d)
+	}
+	select {
+	case <-b.C:
+		t.Error("backoff timer triggered after Succeed")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	r := w.NewBackoffTimer(time.Second, time.Minute, 3, rand.NewLCG(7))
+	defer r.Stop()
+	for i := 0; i < 10; i++ {
+		cur := r.Current()
+		if d := r.Fail(); d < cur/2 || d > cur {
+			t.Errorf("Fail() = %v, want in [%v, %v]", d, cur/2, cur)
+		}
+	}
+}
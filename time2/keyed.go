@@ -0,0 +1,68 @@
+package time2
+
+import "time"
+
+type keyedTimer struct {
+	timer    *Timer
+	deadline time.Time
+}
+
+func ScheduleKey(key string, d time.Duration, f func()) {
+	defaultWheel.ScheduleKey(key, d, f)
+}
+
+func CancelKey(key string) bool {
+	return defaultWheel.CancelKey(key)
+}
+
+func Pending(key string) (time.Time, bool) {
+	return defaultWheel.Pending(key)
+}
+
+// ScheduleKey d 之后执行 f, 替换同一个 key 之前未触发的定时器
+// 重复调用即可实现按 key 防抖
+func (w *Wheel) ScheduleKey(key string, d time.Duration, f func()) {
+	k := &keyedTimer{deadline: time.Now().Add(d)}
+
+	w.keysMu.Lock()
+	defer w.keysMu.Unlock()
+	if w.keys == nil {
+		w.keys = make(map[string]*keyedTimer)
+	}
+	if old, ok := w.keys[key]; ok {
+		old.timer.Stop()
+	}
+	w.keys[key] = k
+	k.timer = w.AfterFunc(d, func() {
+		w.keysMu.Lock()
+		if w.keys[key] != k { // 已经被取消或替换
+			w.keysMu.Unlock()
+			return
+		}
+		delete(w.keys, key)
+		w.keysMu.Unlock()
+		f()
+	})
+}
+
+// CancelKey 取消 key 对应的定时器, 返回是否存在未触发的定时器
+func (w *Wheel) CancelKey(key string) bool {
+	w.keysMu.Lock()
+	defer w.keysMu.Unlock()
+	k, ok := w.keys[key]
+	if ok {
+		k.timer.Stop()
+		delete(w.keys, key)
+	}
+	return ok
+}
+
+// Pending 返回 key 对应的定时器的到期时间
+func (w *Wheel) Pending(key string) (time.Time, bool) {
+	w.keysMu.Lock()
+	defer w.keysMu.Unlock()
+	if k, ok := w.keys[key]; ok {
+		return k.deadline, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,82 @@
+package time2
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduleKey(t *testing.T) {
+	w := NewWheel(10 * time.Millisecond)
+	defer w.Stop()
+
+	var first, second, cancelled int32
+	done := make(chan struct{})
+	w.ScheduleKey("session", 50*time.Millisecond, func() {
+		atomic.AddInt32(&first, 1)
+	})
+	w.ScheduleKey("session", 100*time.Millisecond, func() {
+		atomic.AddInt32(&second, 1)
+		close(done)
+	})
+	w.ScheduleKey("other", 50*time.Millisecond, func() {
+		atomic.AddInt32(&cancelled, 1)
+	})
+
+	if deadline, ok := w.Pending("session"); !ok || time.Until(deadline) < 50*time.Millisecond {
+		t.Errorf("Pending(session) = %v, %v", deadline, ok)
+	}
+	if !w.CancelKey("other") {
+		t.Error("CancelKey(other) = false")
+	}
+	if w.CancelKey("other") {
+		t.Error("CancelKey(other) twice = true")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("keyed timer was not triggered")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&first); n != 0 {
+		t.Errorf("replaced timer triggered %d times", n)
+	}
+	if n := atomic.LoadInt32(&second); n != 1 {
+		t.Errorf("timer triggered %d times", n)
+	}
+	if n := atomic.LoadInt32(&cancelled); n != 0 {
+		t.Errorf("cancelled timer triggered %d times", n)
+	}
+	if _, ok := w.Pending("session"); ok {
+		t.Error("Pending(session) after fired")
+	}
+}
+
+func TestScheduleKeyConcurrent(t *testing.T) {
+	w := NewWheel(10 * time.Millisecond)
+	defer w.Stop()
+
+	const keys = 10
+	var counts [keys]int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			k := i % keys
+			w.ScheduleKey(strconv.Itoa(k), 50*time.Millisecond, func() {
+				atomic.AddInt32(&counts[k], 1)
+			})
+		}(i)
+	}
+	wg.Wait()
+	time.Sleep(200 * time.Millisecond)
+	for k := range counts {
+		if n := atomic.LoadInt32(&counts[k]); n != 1 {
+			t.Errorf("key %d triggered %d times", k, n)
+		}
+	}
+}
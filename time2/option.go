@@ -1,21 +1,214 @@
 package time2
 
 import (
+	"math/bits"
+	"strconv"
+	"sync/atomic"
+	"time"
+
 	"github.com/panjf2000/ants/v2"
+	"github.com/xsean2020/misc"
 )
 
+// Rounding 决定定时时长如何对齐到 tick 边界
+type Rounding int
+
+const (
+	// 向下取整, 最多提前一个 tick 触发 (默认)
+	RoundFloor Rounding = iota
+	// 向上取整, 保证不会早于指定时长触发
+	RoundCeil
+	// 就近取整, 误差不超过半个 tick
+	RoundNearest
+)
+
+// boundary 计算 at 按 tick 对齐后的边界序号
+func (r Rounding) boundary(at, tick time.Duration) uint64 {
+	if at <= 0 {
+		return 0
+	}
+	switch r {
+	case RoundCeil:
+		return uint64((at + tick - 1) / tick)
+	case RoundNearest:
+		return uint64((at + tick/2) / tick)
+	default:
+		return uint64(at / tick)
+	}
+}
+
+// Overload 协程池满载时回调的处理策略
+type Overload int
+
+const (
+	// 新开协程执行 (默认)
+	OverloadSpill Overload = iota
+	// 等待协程池空闲: 无法提交的回调进入有界队列, 由一个协程按顺序等待提交
+	// 时间轮照常转动, 只有队列满时才阻塞提交的协程
+	OverloadBlock
+	// 丢弃回调
+	OverloadDrop
+	// 在提交回调的协程(执行这一批回调的协程)中直接执行
+	// 批量任务本身无法提交时在时间轮协程中执行
+	OverloadInline
+)
+
+// OverloadBlock 策略下重试提交的间隔和等待提交的队列长度
+const (
+	blockInterval  = time.Millisecond
+	blockQueueSize = 1024
+)
+
+// Stats 协程池满载时的统计
+type Stats struct {
+	Dropped uint64 // 丢弃的回调数
+	Spilled uint64 // 溢出到新协程的回调数
+}
+
 type config struct {
 	*ants.Pool
+	overload Overload
+	dropped  atomic.Uint64
+	spilled  atomic.Uint64
+	blocked  chan func() // OverloadBlock 策略下等待协程池的回调
+	dispatch atomic.Bool // 是否有协程在提交 blocked 中的回调, 队列取空并提交完之前为 true
+	rounding Rounding
+	slots    []int
+	horizon  time.Duration
+
+	panicHandler func(string)
+}
+
+// layout 计算每一层的位数
+func (c *config) layout(tick time.Duration) []uint64 {
+	var levels []uint64
+	var total uint64
+	for _, n := range c.slots {
+		if n < 2 || n&(n-1) != 0 {
+			panic("slots(" + strconv.Itoa(n) + ") must be a power of 2")
+		}
+		b := uint64(bits.TrailingZeros(uint(n)))
+		levels = append(levels, b)
+		total += b
+	}
+
+	if c.horizon <= 0 {
+		if len(levels) == 0 {
+			levels = append(levels, tvr_bits)
+			for i := 0; i < tvn_levels; i++ {
+				levels = append(levels, tvn_bits)
+			}
+			total = tvr_bits + tvn_levels*tvn_bits
+		}
+	} else {
+		if len(levels) == 0 {
+			levels = append(levels, tvr_bits)
+			total = tvr_bits
+		}
+		ext := tvn_bits
+		if len(c.slots) > 1 {
+			ext = levels[len(levels)-1]
+		}
+		// 层数不够时按 ext 补齐
+		need := uint64(c.horizon / tick)
+		for total < max_bits && need >= 1<<total {
+			b := ext
+			if total+b > max_bits {
+				b = max_bits - total
+			}
+			levels = append(levels, b)
+			total += b
+		}
+	}
+
+	if total > max_bits {
+		panic("slots overflow, total bits " + strconv.FormatUint(total, 10) + " > " + strconv.FormatUint(max_bits, 10))
+	}
+	return levels
 }
 
 // 覆盖ants.Pool 的方法
 func (c *config) Submit(f func()) {
-	if c.Pool != nil {
-		if err := c.Pool.Submit(f); err == nil {
-			return
+	c.submit(f, c.overload)
+}
+
+// submit 返回 false 表示回调被丢弃
+func (c *config) submit(f func(), policy Overload) bool {
+	fn := func() {
+		c.safeCall(f)
+	}
+	if c.Pool == nil {
+		go fn()
+		return true
+	}
+
+	// 已经有回调在排队时直接排在后面, 保持顺序
+	if policy == OverloadBlock && c.dispatch.Load() {
+		c.enqueue(fn)
+		return true
+	}
+
+	err := c.Pool.Submit(fn)
+	if err == nil {
+		return true
+	}
+
+	switch policy {
+	case OverloadBlock:
+		if err == ants.ErrPoolOverload {
+			c.enqueue(fn)
+			return true
 		}
+	case OverloadDrop:
+		c.dropped.Add(1)
+		return false
+	case OverloadInline:
+		fn()
+		return true
 	}
-	go f()
+	// 协程池已关闭时同样溢出
+	c.spilled.Add(1)
+	go fn()
+	return true
+}
+
+// enqueue 加入等待协程池的队列, 队列满时阻塞
+func (c *config) enqueue(fn func()) {
+	c.blocked <- fn
+	if c.dispatch.CompareAndSwap(false, true) {
+		go c.dispatchBlocked()
+	}
+}
+
+// dispatchBlocked 按顺序把排队的回调提交到协程池, 队列为空时退出
+// 只有这一个协程轮询协程池, 协程数不会随排队的回调增加
+func (c *config) dispatchBlocked() {
+	for {
+		select {
+		case fn := <-c.blocked:
+			err := c.Pool.Submit(fn)
+			for err == ants.ErrPoolOverload {
+				time.Sleep(blockInterval)
+				err = c.Pool.Submit(fn)
+			}
+			if err != nil { // 协程池已关闭
+				c.spilled.Add(1)
+				go fn()
+			}
+		default:
+			c.dispatch.Store(false)
+			// 释放标记之后可能又有回调加入
+			if len(c.blocked) == 0 || !c.dispatch.CompareAndSwap(false, true) {
+				return
+			}
+		}
+	}
+}
+
+// safeCall 执行回调, panic 交给 panicHandler 处理, 不影响其他回调
+func (c *config) safeCall(f func()) {
+	defer misc.PrintPanicStack(c.panicHandler)
+	f()
 }
 
 type Option func(c *config)
@@ -26,3 +219,41 @@ func WithGoroutinePool(p *ants.Pool) Option {
 		c.Pool = p
 	}
 }
+
+// Set overload policy, 仅在设置了 goroutine pool 时生效
+func WithOverload(o Overload) Option {
+	return func(c *config) {
+		c.overload = o
+	}
+}
+
+// Set rounding policy
+func WithRounding(r Rounding) Option {
+	return func(c *config) {
+		c.rounding = r
+	}
+}
+
+// Set slots of each level, 第一个为最底层, 槽数须为 2 的幂
+// 默认为 256, 64, 64, 64, 64
+func WithSlots(slots ...int) Option {
+	return func(c *config) {
+		c.slots = slots
+	}
+}
+
+// Set the maximum duration the wheel holds without cascading,
+// 层数不足时自动补齐
+func WithHorizon(d time.Duration) Option {
+	return func(c *config) {
+		c.horizon = d
+	}
+}
+
+// Set panic handler, 参数为 panic 信息和调用栈
+// 默认输出到标准库 log
+func WithPanicHandler(h func(string)) Option {
+	return func(c *config) {
+		c.panicHandler = h
+	}
+}
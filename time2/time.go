@@ -15,3 +15,19 @@ func After(d time.Duration) <-chan time.Time {
 func Sleep(d time.Duration) {
 	defaultWheel.Sleep(d)
 }
+
+// Now 返回默认时间轮缓存的时间, 每个 tick (100ms) 更新一次
+// 只需要 tick 级别精度的热路径可以用它代替 time.Now
+func Now() time.Time {
+	return defaultWheel.Now()
+}
+
+// Since 等同于 time2.Now().Sub(t), 精度同 Now
+func Since(t time.Time) time.Duration {
+	return defaultWheel.Since(t)
+}
+
+// Until 等同于 t.Sub(time2.Now()), 精度同 Now
+func Until(t time.Time) time.Duration {
+	return defaultWheel.Until(t)
+}
@@ -2,24 +2,33 @@ package time2
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/xsean2020/misc"
 )
 
-// wheel timer
-const ( // 为啥不固定长度
-	tvn_bits uint64 = 6
-	tvr_bits uint64 = 8
-	tvn_size uint64 = 64  //1 << tvn_bits
-	tvr_size uint64 = 256 //1 << tvr_bits
+// wheel timer, 默认布局
+const (
+	tvn_bits   uint64 = 6
+	tvr_bits   uint64 = 8
+	tvn_levels        = 4
 
-	tvn_mask uint64 = 63  //tvn_size - 1
-	tvr_mask uint64 = 255 //tvr_size -1
+	max_bits uint64 = 63 // 所有层的位数之和上限
 
 	defaultTimerSize = 128
 )
 
 //  6 * 4 + 8 = 32
 
+// level 时间轮中的一层
+type level struct {
+	shift uint64 // 该层槽位对应 jiffies 的起始位
+	bits  uint64
+	mask  uint64
+	vecs  [][]*timer
+}
+
 type timer struct {
 	expires uint64 // 过期时间
 	period  uint64 // 周期时间
@@ -34,29 +43,47 @@ type timer struct {
 }
 
 func (t *timer) when() time.Time {
+	w := t.w
+	w.Lock()
+	defer w.Unlock()
 	t.RLock()
 	defer t.RUnlock()
-	return t.w.tm.Add(t.w.tick * time.Duration(t.expires))
+	// 槽 jiffies 在最近一次 tick 之后的下一个 tick 被处理
+	n := int64(t.expires) - int64(w.jiffies) + 1
+	if n < 1 {
+		n = 1
+	}
+	return w.Now().Add(w.tick * time.Duration(n))
 }
 
 type Wheel struct {
 	sync.Mutex
 	jiffies uint64
 	tm      time.Time
-	tvecs   [5][][]*timer
+	mono    int64        // 创建时的 misc.Nanotime
+	clock   atomic.Int64 // 最近一次 tick 相对 tm 的时间
+	levels  []level
+	horizon uint64 // 可直接放入的最大 jiffies 跨度
 	tick    time.Duration
 	quit    chan struct{}
 	cfg     config
+
+	keysMu sync.Mutex
+	keys   map[string]*keyedTimer
 }
 
 // tick is the time for a jiffies
 func NewWheel(tick time.Duration, opts ...Option) *Wheel {
 	w := new(Wheel)
 	w.quit = make(chan struct{})
+	w.mono = misc.Nanotime() // 先于 tm 取值, 保证计算出的流逝时间不会偏小
 	w.tm = time.Now()
 	for _, opt := range opts {
 		opt(&w.cfg)
 	}
+	if w.cfg.overload == OverloadBlock {
+		w.cfg.blocked = make(chan func(), blockQueueSize)
+	}
 
 	fn := func(size int) [][]*timer {
 		tv := make([][]*timer, size)
@@ -67,10 +94,17 @@ func NewWheel(tick time.Duration, opts ...Option) *Wheel {
 	}
 
 	// init
-	var vecsInitSize = [5]uint64{tvr_size, tvn_size, tvn_size, tvn_size, tvn_size}
-	for i := range w.tvecs {
-		w.tvecs[i] = fn(int(vecsInitSize[i]))
+	var shift uint64
+	for _, bits := range w.cfg.layout(tick) {
+		w.levels = append(w.levels, level{
+			shift: shift,
+			bits:  bits,
+			mask:  1<<bits - 1,
+			vecs:  fn(1 << bits),
+		})
+		shift += bits
 	}
+	w.horizon = 1<<shift - 1
 
 	w.jiffies = 0
 	w.tick = tick
@@ -81,37 +115,27 @@ func NewWheel(tick time.Duration, opts ...Option) *Wheel {
 
 func (w *Wheel) unsafeAdd(t *timer) {
 	expires := t.expires
+	if expires < w.jiffies { // 已经过期, 放到下一个要处理的槽
+		expires = w.jiffies
+	}
+
+	idx := expires - w.jiffies // 判断在那一个区间内
+	if idx > w.horizon {       // 超出范围, 先放在最后一层, 级联时按真实的 expires 重新放置
+		expires = w.horizon + w.jiffies
+		idx = w.horizon
+	}
 
-	idx := t.expires - w.jiffies // 判断在那一个区间内 0 - 512
-
-	var tv [][]*timer
-	var i uint64
-
-	if idx < 0 {
-		i = w.jiffies & tvr_mask
-		tv = w.tvecs[0]
-	} else if idx < tvr_size {
-		i = expires & tvr_mask
-		tv = w.tvecs[0]
-	} else if idx < (1 << (tvr_bits + tvn_bits)) {
-		i = (expires >> tvr_bits) & tvn_mask
-		tv = w.tvecs[1]
-	} else if idx < (1 << (tvr_bits + 2*tvn_bits)) {
-		i = (expires >> (tvr_bits + tvn_bits)) & tvn_mask
-		tv = w.tvecs[2]
-	} else if idx < (1 << (tvr_bits + 3*tvn_bits)) {
-		i = (expires >> (tvr_bits + 2*tvn_bits)) & tvn_mask
-		tv = w.tvecs[3]
-	} else {
-		if idx > 0x00000000ffffffff { // 溢出
-			idx = 0x00000000ffffffff
-			expires = idx + w.jiffies // 重新调节expire 来确定具体的位置
+	var lv *level
+	for n := range w.levels {
+		lv = &w.levels[n]
+		if idx < 1<<(lv.shift+lv.bits) {
+			break
 		}
-		// 为啥不直接丢到最后一个桶里面
-		i = (expires >> (tvr_bits + 3*tvn_bits)) & tvn_mask
-		tv = w.tvecs[4]
 	}
 
+	i := (expires >> lv.shift) & lv.mask
+	tv := lv.vecs
+
 	tv[i] = append(tv[i], t)
 	t.vecs = tv
 	t.pos = i
@@ -119,7 +143,9 @@ func (w *Wheel) unsafeAdd(t *timer) {
 }
 
 // 滚筒方式
-func (w *Wheel) cascade(tv [][]*timer, index int) int {
+func (w *Wheel) cascade(n int) int {
+	tv := w.levels[n].vecs
+	index := w.getIndex(n)
 	vec := tv[index]
 	tv[index] = vec[:0:defaultTimerSize]
 	for _, t := range vec {
@@ -132,53 +158,78 @@ func (w *Wheel) cascade(tv [][]*timer, index int) int {
 }
 
 func (w *Wheel) getIndex(n int) int {
-	return int((w.jiffies >> (tvr_bits + uint64(n)*tvn_bits)) & tvn_mask)
+	lv := &w.levels[n]
+	return int((w.jiffies >> lv.shift) & lv.mask)
 }
 
-func (w *Wheel) onTick() {
+func (w *Wheel) onTick(now time.Time) {
 	w.Lock()
 
-	index := int(w.jiffies & tvr_mask)
+	index := w.getIndex(0)
 	// 第一级已经触发完毕了 后面的桶向前移动
-	_ = index == 0 &&
-		(w.cascade(w.tvecs[1], w.getIndex(0))) == 0 &&
-		(w.cascade(w.tvecs[2], w.getIndex(1))) == 0 &&
-		(w.cascade(w.tvecs[3], w.getIndex(2))) == 0 &&
-		(w.cascade(w.tvecs[4], w.getIndex(3)) == 0)
+	for n := 1; index == 0 && n < len(w.levels); n++ {
+		if w.cascade(n) != 0 {
+			break
+		}
+	}
 
 	w.jiffies++
+	w.clock.Store(int64(now.Sub(w.tm)))
 
-	vec := w.tvecs[0][index]
-	w.tvecs[0][index] = vec[0:0:defaultTimerSize]
+	root := w.levels[0].vecs
+	vec := root[index]
+	root[index] = vec[0:0:defaultTimerSize]
 	w.Unlock()
 
 	f := func(vec []*timer) {
-		now := time.Now()
+		now := w.Now()
 		for _, t := range vec {
 			if t == nil {
 				continue
 			}
-			t.f(now, t.arg)
-			if t.period > 0 { // 周期型性的 ticker
-				t.Lock() // 针对 tickerwhen 安全访问
-				t.expires = t.period + w.jiffies
-				t.Unlock()
-				w.addTimer(t)
-			}
+			w.fire(t, now)
+			w.reschedule(t)
 		}
 	}
 
 	// 批量执行
 	if len(vec) > 0 {
-		w.cfg.Submit(func() {
+		batch := func() {
 			f(vec)
-		})
+		}
+		switch w.cfg.overload {
+		case OverloadBlock: // 回调无法提交时进入队列, 批量任务不会阻塞, 直接在时间轮协程执行, 避免占用协程池
+			w.cfg.safeCall(batch)
+		case OverloadDrop: // 批量任务包含 channel 通知和 ticker 的重新加入, 不能丢弃
+			w.cfg.submit(batch, OverloadInline)
+		default:
+			w.cfg.Submit(batch)
+		}
 	}
 }
 
-func (w *Wheel) addTimer(t *timer) {
+// fire 执行定时器回调, panic 不影响同一批的其他定时器
+func (w *Wheel) fire(t *timer, now time.Time) {
+	defer misc.PrintPanicStack(w.cfg.panicHandler)
+	t.f(now, t.arg)
+}
+
+// reschedule 周期型的 ticker 以上次到期为基准重新加入, 避免累积误差
+// 批量任务执行过晚时跳过已经错过的周期, 与 time.Ticker 一样丢弃而不是连续补发
+func (w *Wheel) reschedule(t *timer) {
 	w.Lock()
-	w.unsafeAdd(t)
+	t.Lock() // 针对 tickerwhen 安全访问
+	period := t.period
+	if period > 0 {
+		t.expires += period
+		if t.expires < w.jiffies {
+			t.expires += (w.jiffies - t.expires + period - 1) / period * period
+		}
+	}
+	t.Unlock()
+	if period > 0 {
+		w.unsafeAdd(t)
+	}
 	w.Unlock()
 }
 
@@ -192,20 +243,46 @@ func (w *Wheel) delTimer(t *timer) {
 	w.Unlock()
 }
 
+// expiresAt 按取整策略计算 d 之后到期的槽, 需要持有锁
+// 槽 jiffies+n 在最近一次 tick 之后的第 n+1 个 tick 被处理
+func (w *Wheel) expiresAt(d time.Duration) uint64 {
+	elapsed := time.Duration(misc.Nanotime()-w.mono) - time.Duration(w.clock.Load())
+	b := w.cfg.rounding.boundary(elapsed+d, w.tick)
+	if b == 0 {
+		return w.jiffies
+	}
+	return w.jiffies + b - 1
+}
+
+// schedule 计算到期时间并加入时间轮
+func (w *Wheel) schedule(t *timer, when time.Duration, period time.Duration) {
+	w.Lock()
+	t.Lock()
+	t.expires = w.expiresAt(when)
+	t.period = w.periodTicks(period)
+	t.Unlock()
+	w.unsafeAdd(t)
+	w.Unlock()
+}
+
+// periodTicks 周期按取整策略换算为 tick 数, 周期型的至少为 1
+func (w *Wheel) periodTicks(period time.Duration) uint64 {
+	if period <= 0 {
+		return 0
+	}
+	if n := w.cfg.rounding.boundary(period, w.tick); n > 0 {
+		return n
+	}
+	return 1
+}
+
 func (w *Wheel) resetTimer(t *timer, when time.Duration, period time.Duration) {
 	w.delTimer(t)
-	t.expires = w.jiffies + uint64(when/w.tick) // 必然有误差
-	t.period = uint64(period / w.tick)
-	w.addTimer(t)
+	w.schedule(t, when, period)
 }
 
-func (w *Wheel) newTimer(when time.Duration, period time.Duration,
-	f func(time.Time, interface{}), arg interface{}) *timer {
+func (w *Wheel) newTimer(f func(time.Time, interface{}), arg interface{}) *timer {
 	t := new(timer)
-
-	t.expires = w.jiffies + uint64(when/w.tick)
-	t.period = uint64(period / w.tick)
-
 	t.f = f
 	t.arg = arg
 	t.w = w
@@ -218,14 +295,35 @@ func (w *Wheel) run() {
 	defer ticker.Stop()
 	for {
 		select {
-		case <-ticker.C:
-			w.onTick()
+		case now := <-ticker.C:
+			w.onTick(now)
 		case <-w.quit:
 			return
 		}
 	}
 }
 
+// Now 返回最近一次 tick 的时间, 精度为一个 tick
+func (w *Wheel) Now() time.Time {
+	return w.tm.Add(time.Duration(w.clock.Load()))
+}
+
+func (w *Wheel) Since(t time.Time) time.Duration {
+	return w.Now().Sub(t)
+}
+
+func (w *Wheel) Until(t time.Time) time.Duration {
+	return t.Sub(w.Now())
+}
+
+// Stats 返回协程池满载时丢弃和溢出的回调数
+func (w *Wheel) Stats() Stats {
+	return Stats{
+		Dropped: w.cfg.dropped.Load(),
+		Spilled: w.cfg.spilled.Load(),
+	}
+}
+
 func (w *Wheel) Stop() {
 	close(w.quit)
 }
@@ -254,23 +352,31 @@ func (w *Wheel) TickFunc(d time.Duration, period time.Duration, f func()) *Ticke
 		panic("period(" + period.String() + ") less than tick(" + w.tick.String() + ")")
 	}
 
+	// 上一次回调还没有开始执行时丢弃这一次, 与 time.Ticker 一样不会积压
+	var pending atomic.Bool
+	run := func() {
+		pending.Store(false)
+		f()
+	}
 	t := &Ticker{
-		r: w.newTimer(d, period, func(_ time.Time, _ interface{}) {
-			w.cfg.Submit(f)
+		r: w.newTimer(func(_ time.Time, _ interface{}) {
+			if pending.CompareAndSwap(false, true) && !w.cfg.submit(run, w.cfg.overload) {
+				pending.Store(false)
+			}
 		}, nil),
 	}
 
-	w.addTimer(t.r)
+	w.schedule(t.r, d, period)
 	return t
 }
 
 func (w *Wheel) AfterFunc(d time.Duration, f func()) *Timer {
 	t := &Timer{
-		r: w.newTimer(d, 0, func(_ time.Time, _ interface{}) {
+		r: w.newTimer(func(_ time.Time, _ interface{}) {
 			w.cfg.Submit(f)
 		}, nil),
 	}
-	w.addTimer(t.r)
+	w.schedule(t.r, d, 0)
 	return t
 }
 
@@ -278,9 +384,9 @@ func (w *Wheel) NewTimer(d time.Duration) *Timer {
 	c := make(chan time.Time, 1)
 	t := &Timer{
 		C: c,
-		r: w.newTimer(d, 0, sendTime, c),
+		r: w.newTimer(sendTime, c),
 	}
-	w.addTimer(t.r)
+	w.schedule(t.r, d, 0)
 	return t
 }
 
@@ -292,8 +398,8 @@ func (w *Wheel) NewTicker(d time.Duration, p time.Duration) *Ticker {
 	c := make(chan time.Time, 1)
 	t := &Ticker{
 		C: c,
-		r: w.newTimer(d, p, sendTime, c),
+		r: w.newTimer(sendTime, c),
 	}
-	w.addTimer(t.r)
+	w.schedule(t.r, d, p)
 	return t
 }
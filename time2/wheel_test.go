@@ -1,9 +1,13 @@
 package time2
 
 import (
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/panjf2000/ants/v2"
 )
 
 func TestTimer(t *testing.T) {
@@ -68,3 +72,411 @@ func TestTickFunc(t *testing.T) {
 	}
 	w.Stop()
 }
+
+func TestRoundingBoundary(t *testing.T) {
+	const tick = 100 * time.Millisecond
+	cases := []struct {
+		at    time.Duration
+		floor uint64
+		ceil  uint64
+		near  uint64
+	}{
+		{0, 0, 0, 0},
+		{time.Nanosecond, 0, 1, 0},
+		{50 * time.Millisecond, 0, 1, 1},
+		{100 * time.Millisecond, 1, 1, 1},
+		{100*time.Millisecond + time.Nanosecond, 1, 2, 1},
+		{149 * time.Millisecond, 1, 2, 1},
+		{150 * time.Millisecond, 1, 2, 2},
+		{200 * time.Millisecond, 2, 2, 2},
+	}
+	for _, c := range cases {
+		if n := RoundFloor.boundary(c.at, tick); n != c.floor {
+			t.Errorf("floor(%v) = %d, want %d", c.at, n, c.floor)
+		}
+		if n := RoundCeil.boundary(c.at, tick); n != c.ceil {
+			t.Errorf("ceil(%v) = %d, want %d", c.at, n, c.ceil)
+		}
+		if n := RoundNearest.boundary(c.at, tick); n != c.near {
+			t.Errorf("nearest(%v) = %d, want %d", c.at, n, c.near)
+		}
+	}
+}
+
+func TestRoundCeilNeverEarly(t *testing.T) {
+	const tick = 10 * time.Millisecond
+	w := NewWheel(tick, WithRounding(RoundCeil))
+	defer w.Stop()
+
+	durations := []time.Duration{
+		time.Nanosecond,
+		tick / 2,
+		tick - time.Nanosecond,
+		tick,
+		tick + time.Nanosecond,
+		tick * 3 / 2,
+		2 * tick,
+		5*tick + tick/3,
+	}
+	var wg sync.WaitGroup
+	for _, d := range durations {
+		wg.Add(1)
+		go func(d time.Duration) {
+			defer wg.Done()
+			start := time.Now()
+			<-w.NewTimer(d).C
+			if elapsed := time.Since(start); elapsed < d {
+				t.Errorf("timer(%v) fired after %v", d, elapsed)
+			}
+		}(d)
+	}
+	wg.Wait()
+}
+
+func TestTickerPeriod(t *testing.T) {
+	const tick = 10 * time.Millisecond
+	w := NewWheel(tick)
+	defer w.Stop()
+
+	ticker := w.NewTicker(5*tick, 5*tick)
+	<-ticker.C
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		<-ticker.C
+	}
+	// 周期不应累积额外的 tick
+	if elapsed := time.Since(start); elapsed > 4*5*tick+2*tick {
+		t.Errorf("4 periods took %v", elapsed)
+	}
+	ticker.Stop()
+}
+
+// 周期不是 tick 的整数倍时按取整策略换算
+func TestTickerPeriodRounding(t *testing.T) {
+	const tick = 10 * time.Millisecond
+	cases := []struct {
+		rounding Rounding
+		period   time.Duration
+		want     uint64
+	}{
+		{RoundFloor, tick * 3 / 2, 1},
+		{RoundCeil, tick * 3 / 2, 2},
+		{RoundNearest, tick * 3 / 2, 2},
+		{RoundNearest, tick * 5 / 4, 1},
+		{RoundCeil, tick + time.Nanosecond, 2},
+		{RoundCeil, 2 * tick, 2},
+	}
+	for _, c := range cases {
+		w := NewWheel(tick, WithRounding(c.rounding))
+		if n := w.periodTicks(c.period); n != c.want {
+			t.Errorf("rounding %d: periodTicks(%v) = %d, want %d", c.rounding, c.period, n, c.want)
+		}
+		w.Stop()
+	}
+
+	// RoundCeil 下每个周期都不应早于 period
+	const period = tick * 3 / 2
+	w := NewWheel(tick, WithRounding(RoundCeil))
+	defer w.Stop()
+	ticker := w.NewTicker(period, period)
+	defer ticker.Stop()
+	start := time.Now()
+	for i := 1; i <= 5; i++ {
+		<-ticker.C
+		if elapsed := time.Since(start); elapsed < time.Duration(i)*period {
+			t.Errorf("tick %d fired after %v, want >= %v", i, elapsed, time.Duration(i)*period)
+		}
+	}
+}
+
+// 批量任务执行过晚时跳过错过的周期, 不应每个 tick 连续补发
+func TestTickerStall(t *testing.T) {
+	const tick = 10 * time.Millisecond
+	const period = 5 * tick
+	pool, err := ants.NewPool(1, ants.WithNonblocking(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Release()
+	// 批量任务占用唯一的协程, 回调在批量任务中执行, 第一次回调阻塞整个批量任务
+	w := NewWheel(tick, WithGoroutinePool(pool), WithOverload(OverloadInline))
+	defer w.Stop()
+
+	var mu sync.Mutex
+	var calls []time.Time
+	ticker := w.TickFunc(period, period, func() {
+		mu.Lock()
+		calls = append(calls, time.Now())
+		n := len(calls)
+		mu.Unlock()
+		if n == 1 {
+			time.Sleep(300 * time.Millisecond)
+		}
+	})
+	time.Sleep(600 * time.Millisecond)
+	ticker.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) < 3 || len(calls) > 8 {
+		t.Fatalf("ticker called %d times", len(calls))
+	}
+	for i := 2; i < len(calls); i++ {
+		if d := calls[i].Sub(calls[i-1]); d < period-2*tick {
+			t.Errorf("call %d was %v after the previous one", i, d)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  *config
+		tick time.Duration
+		want []uint64
+	}{
+		{"default", &config{}, time.Millisecond, []uint64{8, 6, 6, 6, 6}},
+		{"slots", &config{slots: []int{1024, 16}}, time.Millisecond, []uint64{10, 4}},
+		{"horizon", &config{horizon: time.Minute}, time.Millisecond, []uint64{8, 6, 6}},
+		{"horizon with slots", &config{slots: []int{16, 8}, horizon: time.Second}, time.Millisecond, []uint64{4, 3, 3}},
+		{"coarse", &config{horizon: 30 * 24 * time.Hour}, time.Second, []uint64{8, 6, 6, 6}},
+	}
+	for _, c := range cases {
+		got := c.cfg.layout(c.tick)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: layout = %v, want %v", c.name, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: layout = %v, want %v", c.name, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestBeyondHorizon(t *testing.T) {
+	const tick = 5 * time.Millisecond
+	// 只能直接容纳 16 个 tick
+	w := NewWheel(tick, WithSlots(4, 4), WithRounding(RoundCeil))
+	defer w.Stop()
+
+	const d = 300 * time.Millisecond
+	start := time.Now()
+	<-w.NewTimer(d).C
+	if elapsed := time.Since(start); elapsed < d || elapsed > d+10*tick {
+		t.Errorf("timer(%v) fired after %v", d, elapsed)
+	}
+}
+
+func TestLongDuration(t *testing.T) {
+	w := NewWheel(time.Second, WithSlots(4, 4))
+	defer w.Stop()
+
+	const d = 72 * time.Hour
+	timer := w.AfterFunc(d, func() {})
+	defer timer.Stop()
+	if diff := time.Until(timer.When()) - d; diff < -time.Second || diff > time.Second {
+		t.Errorf("When() is %v away from %v", diff, d)
+	}
+}
+
+func TestPanicIsolation(t *testing.T) {
+	var mu sync.Mutex
+	var panics []string
+	w := NewWheel(10*time.Millisecond, WithPanicHandler(func(msg string) {
+		mu.Lock()
+		panics = append(panics, msg)
+		mu.Unlock()
+	}))
+	defer w.Stop()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	w.AfterFunc(50*time.Millisecond, func() {
+		defer wg.Done()
+		panic("after func")
+	})
+	w.AfterFunc(50*time.Millisecond, func() {
+		wg.Done()
+	})
+
+	ticks := make(chan struct{}, 3)
+	ticker := w.TickFunc(10*time.Millisecond, 10*time.Millisecond, func() {
+		ticks <- struct{}{}
+		panic("tick func")
+	})
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ticks:
+		case <-time.After(time.Second):
+			t.Fatal("tick func stopped after panic")
+		}
+	}
+	ticker.Stop()
+	wg.Wait()
+
+	// 回调里的 panic 会上报, 但不影响时间轮
+	<-w.NewTimer(20 * time.Millisecond).C
+	mu.Lock()
+	defer mu.Unlock()
+	if len(panics) < 4 {
+		t.Errorf("got %d panics, want at least 4", len(panics))
+	}
+}
+
+func TestOverload(t *testing.T) {
+	const n = 5
+	cases := []struct {
+		policy  Overload
+		dropped bool
+		spilled bool
+	}{
+		{OverloadSpill, false, true},
+		{OverloadDrop, true, false},
+		{OverloadInline, false, false},
+		{OverloadBlock, false, false},
+	}
+	for _, c := range cases {
+		pool, err := ants.NewPool(1, ants.WithNonblocking(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewWheel(10*time.Millisecond, WithGoroutinePool(pool), WithOverload(c.policy))
+
+		// 占满协程池
+		release := make(chan struct{})
+		if err := pool.Submit(func() { <-release }); err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var count int32
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			w.AfterFunc(20*time.Millisecond, func() {
+				atomic.AddInt32(&count, 1)
+				wg.Done()
+			})
+		}
+
+		switch c.policy {
+		case OverloadBlock:
+			time.Sleep(100 * time.Millisecond)
+			if atomic.LoadInt32(&count) != 0 {
+				t.Errorf("policy %d: callbacks ran while pool is full", c.policy)
+			}
+			// 等待协程池不应阻塞时间轮
+			select {
+			case <-w.NewTimer(20 * time.Millisecond).C:
+			case <-time.After(time.Second):
+				t.Errorf("policy %d: timer blocked while pool is full", c.policy)
+			}
+			close(release)
+			wg.Wait()
+		case OverloadDrop:
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+			if atomic.LoadInt32(&count) != 0 {
+				t.Errorf("policy %d: dropped callbacks ran", c.policy)
+			}
+		default:
+			wg.Wait()
+			close(release)
+		}
+
+		stats := w.Stats()
+		if c.dropped != (stats.Dropped == n) || c.spilled != (stats.Spilled >= n) {
+			t.Errorf("policy %d: stats = %+v", c.policy, stats)
+		}
+		w.Stop()
+		pool.Release()
+	}
+}
+
+// 协程池满载时排队的回调只占用一个协程, 不随 tick 增加
+func TestOverloadBlockGoroutines(t *testing.T) {
+	pool, err := ants.NewPool(1, ants.WithNonblocking(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Release()
+	w := NewWheel(time.Millisecond, WithGoroutinePool(pool), WithOverload(OverloadBlock))
+	defer w.Stop()
+
+	release := make(chan struct{})
+	if err := pool.Submit(func() { <-release }); err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+
+	// 前一半同时到期, 按加入的顺序提交, 后一半分散在之后的 tick 上
+	const n = 200
+	var wg sync.WaitGroup
+	var order []int
+	var mu sync.Mutex
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		i := i
+		d := time.Millisecond
+		if i >= n/2 {
+			d += time.Duration(i%40) * time.Millisecond
+		}
+		w.AfterFunc(d, func() {
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			wg.Done()
+		})
+	}
+	var ticks int32
+	ticker := w.TickFunc(time.Millisecond, time.Millisecond, func() { atomic.AddInt32(&ticks, 1) })
+	time.Sleep(50 * time.Millisecond)
+	if n := runtime.NumGoroutine(); n > before+2 {
+		t.Errorf("goroutines grew from %d to %d while pool is full", before, n)
+	}
+
+	close(release)
+	wg.Wait()
+	ticker.Stop()
+	for i := 1; i < n/2; i++ {
+		if order[i] < order[i-1] {
+			t.Errorf("callbacks ran out of order: %v", order)
+			break
+		}
+	}
+	// 协程池满载期间 ticker 的回调不积压
+	if n := atomic.LoadInt32(&ticks); n > 10 {
+		t.Errorf("ticker callbacks piled up: %d", n)
+	}
+}
+
+func TestNow(t *testing.T) {
+	const tick = 10 * time.Millisecond
+	w := NewWheel(tick)
+	defer w.Stop()
+
+	last := w.Now()
+	for i := 0; i < 20; i++ {
+		time.Sleep(tick / 3)
+		now := w.Now()
+		real := time.Now()
+		if now.After(real) || real.Sub(now) > 3*tick {
+			t.Errorf("Now() = %v, time.Now() = %v", now, real)
+		}
+		if now.Before(last) {
+			t.Errorf("Now() went backwards: %v < %v", now, last)
+		}
+		last = now
+	}
+
+	start := time.Now()
+	time.Sleep(5 * tick)
+	if d := w.Since(start); d < 2*tick || d > 5*tick {
+		t.Errorf("Since() = %v", d)
+	}
+	if d := w.Until(start.Add(time.Second)); d < time.Second-10*tick || d > time.Second {
+		t.Errorf("Until() = %v", d)
+	}
+}
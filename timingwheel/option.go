@@ -0,0 +1,26 @@
+package timingwheel
+
+// Overflow 超时时长不小于 maxTimeout 时的处理策略
+type Overflow int
+
+const (
+	// 按圈数计数, 同一圈同一个桶的超时共享一个 channel (默认)
+	OverflowCascade Overflow = iota
+	// 截断为 maxTimeout, 超时会提前触发, AfterE 返回 ErrOverflow
+	OverflowClamp
+	// 退化为 time.AfterFunc, AfterE 返回 ErrOverflow
+	OverflowFallback
+)
+
+type config struct {
+	overflow Overflow
+}
+
+type Option func(c *config)
+
+// Set overflow policy
+func WithOverflow(o Overflow) Option {
+	return func(c *config) {
+		c.overflow = o
+	}
+}
@@ -1,64 +1,269 @@
 package timingwheel
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"time"
 )
 
+var (
+	ErrOverflow = errors.New("timeout too much, over maxtimeout")
+	ErrStopped  = errors.New("timing wheel stopped")
+)
+
+// bucket 同一个 tick 到期的等待者共享一个 channel
+type bucket struct {
+	ch    chan struct{}
+	index int              // 所在的桶, -1 表示不在时间轮上
+	round uint64           // 所在的圈, 只对 laps 中的 bucket 有意义
+	refs  int              // 记录引用次数
+	timer *time.Timer      // 退化为 time.AfterFunc 时的定时器
+	fired time.Time        // 关闭 ch 之前写入
+	times []chan time.Time // Timeout.C, 触发时写入触发时间
+}
+
+func newBucket(index int) *bucket {
+	return &bucket{
+		ch:    make(chan struct{}),
+		index: index,
+	}
+}
+
+// fire 需要持有锁
+func (b *bucket) fire(now time.Time) {
+	b.fired = now
+	for _, c := range b.times {
+		c <- now // 容量为 1 且只写入一次, 不会阻塞
+	}
+	b.times = nil
+	close(b.ch)
+}
+
 type TimingWheel struct {
 	sync.Mutex
 	interval   time.Duration
 	ticker     *time.Ticker
 	maxTimeout time.Duration
 	die        chan struct{}
-	chs        []chan struct{}
-	refs       []int // 记录引用次数
+	buckets    []*bucket            // 每个桶下一次触发时共享的 bucket
+	laps       []map[uint64]*bucket // 之后几圈的 bucket, 按圈数索引
+	round      uint64               // 当前圈数
 	pos        int
+	stopOnce   sync.Once
+	cfg        config
 }
 
-func New(interval time.Duration, buckets int) *TimingWheel {
+// New 一圈为 interval*buckets, 更长的超时默认按圈数计数 (OverflowCascade),
+// 例如 New(100*time.Millisecond, 600).After(10*time.Minute) 在第 10 圈触发
+// 需要截断或者退化为 time.AfterFunc 时使用 WithOverflow(OverflowClamp) 或 OverflowFallback
+func New(interval time.Duration, buckets int, opts ...Option) *TimingWheel {
 	w := new(TimingWheel)
+	for _, opt := range opts {
+		opt(&w.cfg)
+	}
 	w.interval = interval
 	w.die = make(chan struct{})
 	w.pos = 0
 	w.maxTimeout = time.Duration(interval * (time.Duration(buckets)))
-	w.chs = make([]chan struct{}, buckets)
-	w.refs = make([]int, buckets)
-	for i := range w.chs {
-		w.chs[i] = make(chan struct{})
+	w.buckets = make([]*bucket, buckets)
+	w.laps = make([]map[uint64]*bucket, buckets)
+	for i := range w.buckets {
+		w.buckets[i] = newBucket(i)
 	}
 	w.ticker = time.NewTicker(interval)
 	go w.run()
 	return w
 }
 
+// Stop 可以重复调用
 func (w *TimingWheel) Stop() {
-	close(w.die)
+	w.stopOnce.Do(func() {
+		close(w.die)
+	})
 }
 
+// Stopped 返回 Stop 之后关闭的 channel
+func (w *TimingWheel) Stopped() <-chan struct{} {
+	return w.die
+}
+
+// After 超过 maxTimeout 时按 Overflow 策略处理, 默认按圈数计数
 func (w *TimingWheel) After(timeout time.Duration) <-chan struct{} {
-	if timeout >= w.maxTimeout {
-		panic("timeout too much, over maxtimeout")
+	return w.bucket(timeout).ch
+}
+
+// AfterE 已经停止返回 ErrStopped
+// 超过 maxTimeout 时, OverflowCascade 正常等待, 其他策略返回 ErrOverflow
+func (w *TimingWheel) AfterE(timeout time.Duration) (<-chan struct{}, error) {
+	select {
+	case <-w.die:
+		return nil, ErrStopped
+	default:
 	}
+	if timeout >= w.maxTimeout && w.cfg.overflow != OverflowCascade {
+		return nil, ErrOverflow
+	}
+	return w.after(w.offset(timeout)).ch, nil
+}
 
+// Register 同 After, cancel 用于提前释放引用, 可以重复调用
+func (w *TimingWheel) Register(timeout time.Duration) (<-chan struct{}, func()) {
+	b := w.bucket(timeout)
+	var once sync.Once
+	return b.ch, func() {
+		once.Do(func() {
+			w.release(b)
+		})
+	}
+}
+
+// Stats 等待者的统计
+type Stats struct {
+	Waiters []int // 每个桶下一次触发时的等待者数
+	Laps    int   // 之后几圈的等待者数
+	Total   int
+}
+
+func (w *TimingWheel) Stats() Stats {
+	w.Lock()
+	defer w.Unlock()
+	stats := Stats{Waiters: make([]int, len(w.buckets))}
+	for i, b := range w.buckets {
+		stats.Waiters[i] = b.refs
+		stats.Total += b.refs
+	}
+	for _, laps := range w.laps {
+		for _, b := range laps {
+			stats.Laps += b.refs
+		}
+	}
+	stats.Total += stats.Laps
+	return stats
+}
+
+// Timeout 同 Register, C 在触发时写入触发时间, 也可以用 FiredAt/Lag 获取触发时间和延迟
+// 不再等待时调用 Stop 释放引用
+func (w *TimingWheel) Timeout(timeout time.Duration) *Timeout {
+	t := &Timeout{
+		w:        w,
+		b:        w.bucket(timeout),
+		c:        make(chan time.Time, 1),
+		deadline: time.Now().Add(timeout),
+	}
+	w.Lock()
+	if t.b.fired.IsZero() {
+		t.b.times = append(t.b.times, t.c)
+	} else {
+		t.c <- t.b.fired
+	}
+	w.Unlock()
+	return t
+}
+
+// Wait 等待 timeout, ctx 先结束时释放引用并返回 ctx.Err()
+func (w *TimingWheel) Wait(ctx context.Context, timeout time.Duration) error {
+	ch, cancel := w.Register(timeout)
+	select {
+	case <-ctx.Done():
+		cancel()
+		return ctx.Err()
+	case <-ch:
+		return nil
+	}
+}
+
+func (w *TimingWheel) bucket(timeout time.Duration) *bucket {
+	if timeout < w.maxTimeout {
+		return w.after(w.offset(timeout))
+	}
+
+	switch w.cfg.overflow {
+	case OverflowFallback:
+		b := newBucket(-1)
+		b.refs = 1
+		b.timer = time.AfterFunc(timeout, func() {
+			w.Lock()
+			b.fire(time.Now())
+			w.Unlock()
+		})
+		return b
+	case OverflowClamp:
+		return w.after(len(w.buckets) - 1)
+	default:
+		return w.after(w.offset(timeout))
+	}
+}
+
+// offset 超时对应的桶相对当前位置的偏移
+// 负数的超时按 0 处理, 在下一个 tick 触发
+func (w *TimingWheel) offset(timeout time.Duration) int {
 	index := int(timeout / w.interval)
-	if 0 < index { // 卫生么要减少
+	if index < 0 {
+		index = 0
+	}
+	if 0 < index { // 当前的桶在下一个 tick 就会触发
 		index--
 	}
+	return index
+}
 
+// after 取得 offset 个 tick 之后触发的 bucket, 同一圈同一个桶共享
+func (w *TimingWheel) after(offset int) *bucket {
 	w.Lock()
-	index = (w.pos + index) % len(w.chs)
-	b := w.chs[index]
-	w.refs[index]++
-	w.Unlock()
+	defer w.Unlock()
+	abs := w.pos + offset
+	index := abs % len(w.buckets)
+	round := w.round + uint64(abs/len(w.buckets))
+
+	b := w.buckets[index]
+	if round != w.visit(index) { // 需要跨圈
+		laps := w.laps[index]
+		if laps == nil {
+			laps = make(map[uint64]*bucket)
+			w.laps[index] = laps
+		}
+		if b = laps[round]; b == nil {
+			b = newBucket(index)
+			b.round = round
+			laps[round] = b
+		}
+	}
+	b.refs++
 	return b
 }
 
+// visit 桶 index 下一次触发时所在的圈
+func (w *TimingWheel) visit(index int) uint64 {
+	if index >= w.pos {
+		return w.round
+	}
+	return w.round + 1
+}
+
+// release 释放一个引用
+func (w *TimingWheel) release(b *bucket) {
+	if b.index < 0 {
+		b.timer.Stop()
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	if !b.fired.IsZero() || b.refs == 0 {
+		return
+	}
+	b.refs--
+	if b.refs == 0 && w.laps[b.index][b.round] == b {
+		delete(w.laps[b.index], b.round)
+	}
+}
+
 func (w *TimingWheel) run() {
 	for {
 		select {
-		case <-w.ticker.C:
-			w.onTicker()
+		case now := <-w.ticker.C:
+			w.onTicker(now)
 		case <-w.die:
 			w.ticker.Stop()
 			return
@@ -66,16 +271,91 @@ func (w *TimingWheel) run() {
 	}
 }
 
-func (w *TimingWheel) onTicker() {
+func (w *TimingWheel) onTicker(now time.Time) {
 	w.Lock()
 	defer w.Unlock()
-	pos := w.pos
-	w.pos = (w.pos + 1) % len(w.chs)
-	if w.refs[pos] == 0 {
-		return
+	pos, round := w.pos, w.round
+	w.pos = (w.pos + 1) % len(w.buckets)
+	if w.pos == 0 {
+		w.round++
 	}
-	w.refs[pos] = 0
-	lastC := w.chs[pos]
-	w.chs[pos] = make(chan struct{})
-	close(lastC)
+
+	// 下一圈的 bucket 移到桶上
+	next := w.laps[pos][round+1]
+	if next != nil {
+		delete(w.laps[pos], round+1)
+	}
+
+	last := w.buckets[pos]
+	if last.refs > 0 {
+		last.fire(now)
+		if next == nil {
+			next = newBucket(pos)
+		}
+	}
+	if next != nil {
+		w.buckets[pos] = next
+	}
+}
+
+// Timeout 等待超时的句柄
+type Timeout struct {
+	w        *TimingWheel
+	b        *bucket
+	c        chan time.Time
+	deadline time.Time
+	once     sync.Once
+}
+
+// Stop 提前释放引用, 之后 C 不再写入, 可以重复调用, 触发后调用没有影响
+func (t *Timeout) Stop() {
+	t.once.Do(func() {
+		t.w.release(t.b)
+		t.w.Lock()
+		for i, c := range t.b.times {
+			if c == t.c {
+				t.b.times = append(t.b.times[:i], t.b.times[i+1:]...)
+				break
+			}
+		}
+		t.w.Unlock()
+	})
+}
+
+// C 触发时写入触发时间, 与 time.Timer.C 相同, 不需要轮询 FiredAt
+func (t *Timeout) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *Timeout) Done() <-chan struct{} {
+	return t.b.ch
+}
+
+// Deadline 期望的触发时间
+func (t *Timeout) Deadline() time.Time {
+	return t.deadline
+}
+
+// FiredAt 实际触发的时间, 未触发返回零值
+func (t *Timeout) FiredAt() time.Time {
+	select {
+	case <-t.b.ch:
+		return t.b.fired
+	default:
+		return time.Time{}
+	}
+}
+
+// Lag 实际触发时间与期望时间的差, 按桶取整可能为负数
+func (t *Timeout) Lag() time.Duration {
+	fired := t.FiredAt()
+	if fired.IsZero() {
+		return 0
+	}
+	return fired.Sub(t.deadline)
+}
+
+// Bucket 所在的桶, -1 表示退化为 time.AfterFunc
+func (t *Timeout) Bucket() int {
+	return t.b.index
 }
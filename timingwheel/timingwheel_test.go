@@ -1,6 +1,7 @@
 package timingwheel
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -43,3 +44,230 @@ func TestTimingWheel(t *testing.T) {
 	default:
 	}
 }
+
+func TestOverflow(t *testing.T) {
+	const interval = 10 * time.Millisecond
+	const buckets = 10
+	const timeout = 250 * time.Millisecond
+
+	cases := []struct {
+		overflow Overflow
+		min, max time.Duration
+	}{
+		{OverflowClamp, interval * (buckets - 2), interval * (buckets + 2)},
+		{OverflowFallback, timeout, timeout + 2*interval},
+		{OverflowCascade, timeout - 2*interval, timeout + 2*interval},
+	}
+	// 默认按圈数计数
+	tw := New(interval, buckets)
+	start := time.Now()
+	ch, err := tw.AfterE(timeout)
+	if err != nil {
+		t.Fatalf("default AfterE() error = %v", err)
+	}
+	<-ch
+	if dur := time.Since(start); dur < timeout-2*interval || dur > timeout+2*interval {
+		t.Errorf("default AfterE() took %v", dur)
+	}
+	tw.Stop()
+
+	for _, c := range cases {
+		tw := New(interval, buckets, WithOverflow(c.overflow))
+		ch, err := tw.AfterE(timeout)
+		if c.overflow == OverflowCascade {
+			// 按圈数计数, 不需要拒绝
+			if err != nil {
+				t.Errorf("overflow %d: AfterE() error = %v", c.overflow, err)
+			} else {
+				start := time.Now()
+				<-ch
+				if dur := time.Since(start); dur < c.min || dur > c.max {
+					t.Errorf("overflow %d: AfterE() took %v, expected %v ~ %v", c.overflow, dur, c.min, c.max)
+				}
+			}
+		} else if err != ErrOverflow {
+			t.Errorf("overflow %d: AfterE() error = %v, want %v", c.overflow, err, ErrOverflow)
+		}
+
+		start := time.Now()
+		<-tw.After(timeout)
+		if dur := time.Since(start); dur < c.min || dur > c.max {
+			t.Errorf("overflow %d: After() took %v, expected %v ~ %v", c.overflow, dur, c.min, c.max)
+		}
+		tw.Stop()
+		tw.Stop()
+		if _, err := tw.AfterE(interval); err != ErrStopped {
+			t.Errorf("AfterE() after Stop error = %v, want %v", err, ErrStopped)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	const interval = 10 * time.Millisecond
+	tw := New(interval, 100)
+	defer tw.Stop()
+
+	to := tw.Timeout(55 * time.Millisecond)
+	if !to.FiredAt().IsZero() || to.Lag() != 0 {
+		t.Errorf("FiredAt() = %v before fired", to.FiredAt())
+	}
+	if b := to.Bucket(); b < 0 || b >= 100 {
+		t.Errorf("Bucket() = %d", b)
+	}
+	fired := <-to.C()
+	<-to.Done()
+	if !fired.Equal(to.FiredAt()) {
+		t.Errorf("C() = %v, FiredAt() = %v", fired, to.FiredAt())
+	}
+	if to.FiredAt().IsZero() {
+		t.Error("FiredAt() is zero after fired")
+	}
+	if lag := to.Lag(); lag < -2*interval || lag > 2*interval {
+		t.Errorf("Lag() = %v", lag)
+	}
+
+	if err := tw.Wait(context.Background(), 20*time.Millisecond); err != nil {
+		t.Errorf("Wait() = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := tw.Wait(ctx, 500*time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("Wait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// ctx 先结束时应释放引用
+	if stats := tw.Stats(); stats.Total != 0 {
+		t.Errorf("Stats() after Wait = %+v", stats)
+	}
+
+	to = tw.Timeout(500 * time.Millisecond)
+	if stats := tw.Stats(); stats.Total != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+	to.Stop()
+	to.Stop()
+	if stats := tw.Stats(); stats.Total != 0 {
+		t.Errorf("Stats() after Stop = %+v", stats)
+	}
+
+	// 超过 maxTimeout 退化为 time.AfterFunc 时同样写入触发时间
+	fallback := New(interval, 10, WithOverflow(OverflowFallback))
+	defer fallback.Stop()
+	to = fallback.Timeout(150 * time.Millisecond)
+	select {
+	case fired := <-to.C():
+		if to.Bucket() != -1 || !fired.Equal(to.FiredAt()) {
+			t.Errorf("fallback Timeout bucket = %d, C() = %v, FiredAt() = %v", to.Bucket(), fired, to.FiredAt())
+		}
+	case <-time.After(time.Second):
+		t.Error("fallback Timeout.C() not fired")
+	}
+}
+
+func TestMultiLap(t *testing.T) {
+	const interval = 10 * time.Millisecond
+	const buckets = 10
+	tw := New(interval, buckets, WithOverflow(OverflowCascade))
+	defer tw.Stop()
+
+	// 同一圈同一个桶共享 channel, 不同圈互不影响
+	c1 := tw.After(350 * time.Millisecond)
+	c2 := tw.After(355 * time.Millisecond)
+	c3 := tw.After(250 * time.Millisecond)
+	c4 := tw.After(50 * time.Millisecond)
+	if c1 != c2 {
+		t.Error("timeouts in the same lap and bucket should share a channel")
+	}
+	if c1 == c3 || c3 == c4 {
+		t.Error("timeouts in different laps should not share a channel")
+	}
+
+	start := time.Now()
+	for _, c := range []struct {
+		ch      <-chan struct{}
+		timeout time.Duration
+	}{
+		{c4, 50 * time.Millisecond},
+		{c3, 250 * time.Millisecond},
+		{c1, 350 * time.Millisecond},
+	} {
+		<-c.ch
+		if dur := time.Since(start); dur < c.timeout-2*interval || dur > c.timeout+2*interval {
+			t.Errorf("After(%v) took %v", c.timeout, dur)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	const interval = 10 * time.Millisecond
+	const buckets = 10
+	tw := New(interval, buckets, WithOverflow(OverflowCascade))
+	defer tw.Stop()
+
+	_, cancel1 := tw.Register(55 * time.Millisecond)
+	_, cancel2 := tw.Register(55 * time.Millisecond)
+	_, cancel3 := tw.Register(355 * time.Millisecond)
+	c4, cancel4 := tw.Register(20 * time.Millisecond)
+
+	stats := tw.Stats()
+	if stats.Total != 4 || stats.Laps != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+	var max int
+	for _, n := range stats.Waiters {
+		if n > max {
+			max = n
+		}
+	}
+	if max != 2 {
+		t.Errorf("Stats().Waiters = %v", stats.Waiters)
+	}
+
+	cancel1()
+	cancel1()
+	cancel3()
+	if stats := tw.Stats(); stats.Total != 2 || stats.Laps != 0 {
+		t.Errorf("Stats() after cancel = %+v", stats)
+	}
+
+	<-c4
+	cancel4() // 触发后取消没有影响
+	cancel2()
+	if stats := tw.Stats(); stats.Total != 0 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	fallback := New(interval, buckets, WithOverflow(OverflowFallback))
+	defer fallback.Stop()
+	c, cancel := fallback.Register(200 * time.Millisecond)
+	cancel()
+	select {
+	case <-c:
+		t.Error("cancelled fallback timeout fired")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+// 负数的超时在下一个 tick 触发, 不会越界或者放到永远不触发的圈上
+func TestNegativeTimeout(t *testing.T) {
+	const interval = 10 * time.Millisecond
+	tw := New(interval, 10)
+	defer tw.Stop()
+
+	for i := 0; i < 3; i++ {
+		ch, err := tw.AfterE(-time.Second)
+		if err != nil {
+			t.Fatalf("AfterE(-1s) error = %v", err)
+		}
+		select {
+		case <-ch:
+		case <-time.After(5 * interval):
+			t.Fatalf("AfterE(-1s) not fired, stats = %+v", tw.Stats())
+		}
+		<-tw.After(-interval)
+		// 让 pos 离开 0
+		time.Sleep(3 * interval)
+	}
+	if stats := tw.Stats(); stats.Total != 0 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
@@ -0,0 +1,116 @@
+package trace
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type attrKind uint8
+
+const (
+	kindString attrKind = iota + 1
+	kindInt
+	kindBool
+	kindDuration
+)
+
+// Attribute 带类型的属性, 不需要装箱为 interface{}
+type Attribute struct {
+	Key  string
+	kind attrKind
+	num  int64
+	str  string
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, kind: kindString, str: value}
+}
+
+func Int(key string, value int) Attribute {
+	return Int64(key, int64(value))
+}
+
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, kind: kindInt, num: value}
+}
+
+func Bool(key string, value bool) Attribute {
+	a := Attribute{Key: key, kind: kindBool}
+	if value {
+		a.num = 1
+	}
+	return a
+}
+
+func Duration(key string, value time.Duration) Attribute {
+	return Attribute{Key: key, kind: kindDuration, num: int64(value)}
+}
+
+// Value 返回装箱后的值, Int 和 Int64 都返回 int64
+func (a Attribute) Value() interface{} {
+	switch a.kind {
+	case kindString:
+		return a.str
+	case kindInt:
+		return a.num
+	case kindBool:
+		return a.num != 0
+	case kindDuration:
+		return time.Duration(a.num)
+	}
+	return nil
+}
+
+func (a Attribute) field() zap.Field {
+	switch a.kind {
+	case kindString:
+		return zap.String(a.Key, a.str)
+	case kindInt:
+		return zap.Int64(a.Key, a.num)
+	case kindBool:
+		return zap.Bool(a.Key, a.num != 0)
+	case kindDuration:
+		return zap.Duration(a.Key, time.Duration(a.num))
+	}
+	return zap.Skip()
+}
+
+// SetAttributes 向 Span 添加带类型的属性, 同名的属性会被覆盖
+func (traceCtx *TraceCtx) SetAttributes(attrs ...Attribute) *TraceCtx {
+	if !traceCtx.Sampled() {
+		return traceCtx
+	}
+	span := traceCtx.Span
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	if span.ended {
+		return traceCtx
+	}
+	for _, a := range attrs {
+		delete(span.Attributes, a.Key)
+		span.setTyped(a)
+	}
+	return traceCtx
+}
+
+// setTyped 需要持有锁
+func (span *Span) setTyped(a Attribute) {
+	for i := range span.typed {
+		if span.typed[i].Key == a.Key {
+			span.typed[i] = a
+			return
+		}
+	}
+	span.typed = append(span.typed, a)
+}
+
+// deleteTyped 需要持有锁
+func (span *Span) deleteTyped(key string) {
+	for i := range span.typed {
+		if span.typed[i].Key == key {
+			span.typed = append(span.typed[:i], span.typed[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestConcurrentSpan(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	traceCtx := New(context.Background(), zap.New(core))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				traceCtx.AddAttribute("any", j).
+					SetAttributes(Int("worker", i), Bool("ok", true)).
+					AddEvent("step", nil).
+					SetStatus(StatusOK, "")
+				if j == 50 {
+					traceCtx.RecordError(errors.New("failed"))
+				}
+			}
+			traceCtx.EndTrace()
+		}(i)
+	}
+	wg.Wait()
+
+	// 结束之后的修改被忽略
+	traceCtx.AddAttribute("late", 1).SetAttributes(String("late", "x")).AddEvent("late", nil)
+	traceCtx.EndTrace()
+	if logs.Len() != 1 {
+		t.Errorf("EndTrace logged %d times", logs.Len())
+	}
+	if _, ok := traceCtx.Span.Attributes["late"]; ok {
+		t.Error("attribute added after end")
+	}
+}
+
+func TestTypedAttributes(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	traceCtx := New(context.Background(), zap.New(core))
+	traceCtx.AddAttribute("a", "boxed").
+		SetAttributes(String("a", "typed"), Int("n", 1), Bool("b", true), Duration("d", time.Second)).
+		SetAttributes(Int64("n", 2)).
+		AddAttribute("b", "boxed")
+	traceCtx.EndTrace()
+
+	data := traceCtx.data(time.Now())
+	want := map[string]interface{}{"a": "typed", "n": int64(2), "b": "boxed", "d": time.Second}
+	for k, v := range want {
+		if data.Attributes[k] != v {
+			t.Errorf("%s = %#v, want %#v", k, data.Attributes[k], v)
+		}
+	}
+
+	fields := logs.All()[0].ContextMap()
+	if fields["a"] != "typed" || fields["n"] != int64(2) || fields["b"] != "boxed" || fields["d"] != time.Second {
+		t.Errorf("fields = %v", fields)
+	}
+}
+
+func BenchmarkAddAttribute(b *testing.B) {
+	traceCtx := New(context.Background(), nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		traceCtx.AddAttribute("n", i)
+	}
+}
+
+func BenchmarkSetAttributes(b *testing.B) {
+	traceCtx := New(context.Background(), nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		traceCtx.SetAttributes(Int("n", i))
+	}
+}
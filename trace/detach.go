@@ -0,0 +1,61 @@
+package trace
+
+import (
+	"context"
+	"time"
+
+	"github.com/xsean2020/misc"
+)
+
+// AttrQueueWait Wrap 的函数从提交到开始执行等待的时间
+const AttrQueueWait = "queue.wait"
+
+// detached 保留 parent 的值, 但不会随 parent 取消或超时
+type detached struct {
+	parent context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
+
+func (d detached) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+// Detach 返回不会随 ctx 取消的 context, 保留其中的追踪
+// 用于请求结束之后仍然在后台执行的任务, 之后的 span 仍然是 ctx 中 span 的子 span
+func Detach(ctx context.Context) context.Context {
+	return detached{parent: ctx}
+}
+
+// Wrap 返回在后台执行 f 的函数, 用于 ants.Pool.Submit, time2.AfterFunc 等
+// 执行时以 name 开始 ctx 的子 span 并传给 f, 记录提交之后等待执行的时间
+// span 的位置为调用 Wrap 的位置, name 为空时使用调用 Wrap 的函数名
+func Wrap(ctx context.Context, name string, f func(ctx context.Context)) func() {
+	ctx = Detach(ctx)
+	frame, ok := misc.Caller(2)
+	if name == "" && ok {
+		name = frame.Function
+	}
+	submitted := time.Now()
+	return func() {
+		span := StartSpan(ctx, name)
+		span.update(func(s *Span) {
+			if ok {
+				s.File, s.Line = frame.File, frame.Line
+			}
+			s.setTyped(Duration(AttrQueueWait, time.Since(submitted)))
+		})
+		defer span.EndTrace()
+		f(span)
+	}
+}
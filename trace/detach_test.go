@@ -0,0 +1,100 @@
+package trace
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchLinks(t *testing.T) {
+	rec := NewSpanRecorder(0)
+	SetExporter(rec)
+	defer SetExporter(nil)
+
+	// 多个请求合并为一个批处理
+	var requests []*TraceCtx
+	var links []Link
+	for i := 0; i < 3; i++ {
+		req := New(context.Background(), nil)
+		requests = append(requests, req)
+		link, ok := LinkTo(req, map[string]interface{}{"index": i})
+		if !ok {
+			t.Fatal("LinkTo failed")
+		}
+		links = append(links, link)
+	}
+	if _, ok := LinkTo(context.Background(), nil); ok {
+		t.Error("LinkTo without trace")
+	}
+
+	batch := StartSpan(requests[0], "flush", WithNewRoot(), WithLinks(links[:2]...))
+	batch.AddLink(requests[2], nil).AddLink(context.Background(), nil)
+	batch.EndTrace()
+
+	if batch.TraceID == requests[0].TraceID || batch.Span.ParentSpanID.IsValid() {
+		t.Error("WithNewRoot did not start a new trace")
+	}
+	data := rec.ByName("flush")
+	if len(data) != 1 || len(data[0].Links) != 3 {
+		t.Fatalf("batch = %+v", data)
+	}
+	for i, link := range data[0].Links {
+		if link.SpanContext.TraceID != requests[i].TraceID || link.SpanContext.SpanID != requests[i].Span.SpanID {
+			t.Errorf("link %d = %+v", i, link.SpanContext)
+		}
+	}
+
+	span := (&OTLPExporter{}).request(data).ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if len(span.Links) != 3 || span.Links[0].TraceID != requests[0].TraceID.String() || len(span.Links[0].Attributes) != 1 {
+		t.Errorf("otlp links = %+v", span.Links)
+	}
+}
+
+func TestDetach(t *testing.T) {
+	rec := NewSpanRecorder(0)
+	SetExporter(rec)
+	defer SetExporter(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	req := StartSpan(ctx, "request")
+	bg := Detach(req)
+	if _, ok := bg.Deadline(); ok || bg.Done() != nil || bg.Err() != nil {
+		t.Error("detached context inherits cancellation")
+	}
+	if FromContext(bg) != req {
+		t.Error("detached context lost the span")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	work := func(ctx context.Context) {
+		defer wg.Done()
+		if ctx.Err() != nil {
+			t.Error("background task canceled with the request")
+		}
+	}
+	task := Wrap(req, "", work)
+	_, file, line, _ := runtime.Caller(0)
+	cancel()
+	req.EndTrace()
+	time.Sleep(10 * time.Millisecond)
+	go task()
+	wg.Wait()
+
+	spans := rec.ByName("github.com/xsean2020/misc/trace.TestDetach")
+	if len(spans) != 1 {
+		t.Fatalf("spans = %+v", rec.Spans())
+	}
+	s := spans[0]
+	if s.TraceID != req.TraceID || s.ParentSpanID != req.Span.SpanID {
+		t.Error("background span is not a child of the request")
+	}
+	if s.File != file || s.Line != line-1 {
+		t.Errorf("location = %s:%d, want %s:%d", s.File, s.Line, file, line-1)
+	}
+	if wait, _ := s.Attributes[AttrQueueWait].(time.Duration); wait < 10*time.Millisecond {
+		t.Errorf("queue wait = %v", wait)
+	}
+}
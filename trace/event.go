@@ -0,0 +1,167 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xsean2020/misc"
+)
+
+// Event span 中带时间戳的事件
+type Event struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Link 指向另一个 span, 用于批处理等一个 span 有多个原因的情况
+type Link struct {
+	SpanContext SpanContext            `json:"-"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// LinkTo 指向 ctx 中的 span, ctx 中没有追踪信息时 ok 为 false
+func LinkTo(ctx context.Context, attrs map[string]interface{}) (link Link, ok bool) {
+	sc, ok := SpanContextFrom(ctx)
+	if !ok || !sc.IsValid() {
+		return link, false
+	}
+	return Link{SpanContext: sc, Attributes: attrs}, true
+}
+
+// AddLink 指向 ctx 中的 span, ctx 中没有追踪信息时忽略
+func (traceCtx *TraceCtx) AddLink(ctx context.Context, attrs map[string]interface{}) *TraceCtx {
+	if link, ok := LinkTo(ctx, attrs); ok {
+		traceCtx.update(func(span *Span) {
+			span.Links = append(span.Links, link)
+		})
+	}
+	return traceCtx
+}
+
+// StatusCode 与 OTLP 的取值一致
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+func (c StatusCode) String() string {
+	switch c {
+	case StatusOK:
+		return "ok"
+	case StatusError:
+		return "error"
+	}
+	return "unset"
+}
+
+type Status struct {
+	Code    StatusCode
+	Message string
+}
+
+// SpanKind 与 OTLP 的取值一致
+type SpanKind int
+
+const (
+	SpanKindUnspecified SpanKind = iota
+	SpanKindInternal
+	SpanKindServer
+	SpanKindClient
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindInternal:
+		return "internal"
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	}
+	return "unspecified"
+}
+
+// RecordError 写入的事件名和属性, 与 OpenTelemetry 的约定一致
+const (
+	ExceptionEvent      = "exception"
+	ExceptionType       = "exception.type"
+	ExceptionMessage    = "exception.message"
+	ExceptionStacktrace = "exception.stacktrace"
+	ExceptionChain      = "exception.chain"
+)
+
+// AddEvent 记录一个事件, attrs 可以为 nil
+func (traceCtx *TraceCtx) AddEvent(name string, attrs map[string]interface{}) *TraceCtx {
+	now := time.Now()
+	traceCtx.update(func(span *Span) {
+		span.Events = append(span.Events, Event{
+			Name:       name,
+			Time:       now,
+			Attributes: attrs,
+		})
+	})
+	return traceCtx
+}
+
+// SetKind 设置 span 的类型, 默认 SpanKindInternal
+func (traceCtx *TraceCtx) SetKind(kind SpanKind) *TraceCtx {
+	traceCtx.update(func(span *Span) {
+		span.Kind = kind
+	})
+	return traceCtx
+}
+
+// SetStatus 设置 span 的状态, 只有 StatusError 会保留 msg
+func (traceCtx *TraceCtx) SetStatus(code StatusCode, msg string) *TraceCtx {
+	if code != StatusError {
+		msg = ""
+	}
+	traceCtx.update(func(span *Span) {
+		span.Status = Status{Code: code, Message: msg}
+	})
+	return traceCtx
+}
+
+// setError 状态未设置时设为 StatusError
+func (traceCtx *TraceCtx) setError(msg string) {
+	traceCtx.update(func(span *Span) {
+		if span.Status.Code == StatusUnset {
+			span.Status = Status{Code: StatusError, Message: msg}
+		}
+	})
+}
+
+// RecordError 记录 exception 事件, 包括调用栈和 misc.WarpError 每一层的信息
+// 状态未设置时同时设为 StatusError
+func (traceCtx *TraceCtx) RecordError(err error) *TraceCtx {
+	if err == nil || !traceCtx.Sampled() {
+		return traceCtx
+	}
+
+	// 最内层的错误作为类型
+	var chain []string
+	cause := err
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if warp, ok := e.(*misc.WarpError); ok {
+			chain = append(chain, warp.Message())
+		} else {
+			chain = append(chain, e.Error())
+		}
+		cause = e
+	}
+
+	traceCtx.AddEvent(ExceptionEvent, map[string]interface{}{
+		ExceptionType:       fmt.Sprintf("%T", cause),
+		ExceptionMessage:    err.Error(),
+		ExceptionStacktrace: misc.StackInfo(3),
+		ExceptionChain:      chain,
+	})
+	traceCtx.setError(err.Error())
+	return traceCtx
+}
@@ -0,0 +1,73 @@
+package trace
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/xsean2020/misc"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRecordError(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	traceCtx := New(context.Background(), zap.New(core))
+	traceCtx.AddEvent("cache miss", map[string]interface{}{"key": "user:1"})
+	traceCtx.RecordError(misc.Wrap(misc.Wrap(io.EOF, "read body"), "load user"))
+	traceCtx.EndTrace()
+
+	span := traceCtx.Span
+	if span.Status.Code != StatusError || span.Status.Message != "EOF warp by read body warp by load user" {
+		t.Errorf("status = %+v", span.Status)
+	}
+	if len(span.Events) != 2 || span.Events[0].Name != "cache miss" || span.Events[1].Name != ExceptionEvent {
+		t.Fatalf("events = %+v", span.Events)
+	}
+	attrs := span.Events[1].Attributes
+	chain := attrs[ExceptionChain].([]string)
+	if strings.Join(chain, ",") != "load user,read body,EOF" {
+		t.Errorf("chain = %v", chain)
+	}
+	if attrs[ExceptionType] != "*errors.errorString" {
+		t.Errorf("type = %v", attrs[ExceptionType])
+	}
+	stack := attrs[ExceptionStacktrace].(string)
+	if !strings.Contains(stack, "TestRecordError") || strings.Contains(stack, "(*TraceCtx).RecordError") {
+		t.Errorf("stack = %s", stack)
+	}
+
+	// 失败的 span 以 Error 级别输出
+	entries := logs.All()
+	if len(entries) != 1 || entries[0].Level != zapcore.ErrorLevel {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if fields := entries[0].ContextMap(); fields["status"] != "error" || fields["events"] == nil {
+		t.Errorf("fields = %v", fields)
+	}
+
+	// SetStatus 优先于 RecordError
+	ok := New(context.Background(), nil).SetStatus(StatusOK, "ignored").RecordError(io.EOF)
+	if ok.Span.Status != (Status{Code: StatusOK}) {
+		t.Errorf("status = %+v", ok.Span.Status)
+	}
+}
+
+func TestOTLPEvents(t *testing.T) {
+	traceCtx := New(context.Background(), nil).RecordError(misc.Wrap(io.EOF, "read"))
+	e := &OTLPExporter{cfg: otlpConfig{serviceName: "test"}}
+	span := e.request([]SpanData{traceCtx.data(traceCtx.StartTime)}).ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.Status.Code != int(StatusError) || span.Status.Message == "" {
+		t.Errorf("status = %+v", span.Status)
+	}
+	if len(span.Events) != 1 || span.Events[0].Name != ExceptionEvent {
+		t.Fatalf("events = %+v", span.Events)
+	}
+	for _, kv := range span.Events[0].Attributes {
+		if kv.Key == ExceptionChain && (kv.Value.ArrayValue == nil || len(kv.Value.ArrayValue.Values) != 2) {
+			t.Errorf("chain = %+v", kv.Value)
+		}
+	}
+}
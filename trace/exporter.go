@@ -0,0 +1,524 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SpanData 已经结束的 span 的快照
+type SpanData struct {
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	TraceState   string
+	Flags        byte
+	Name         string
+	File         string
+	Line         int
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   map[string]interface{}
+	Events       []Event
+	Links        []Link
+	Status       Status
+	Kind         SpanKind
+}
+
+// Exporter 接收 EndTrace 结束的 span, ExportSpans 在 EndTrace 中同步调用, 不应阻塞
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+var exporter Exporter
+
+// SetExporter 设置全局的 Exporter, nil 表示不导出
+func SetExporter(e Exporter) {
+	exporter = e
+}
+
+// DefaultOTLPEndpoint 本地 collector 的 OTLP/HTTP 地址
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+const scopeName = "github.com/xsean2020/misc/trace"
+
+type otlpConfig struct {
+	endpoint      string
+	file          string
+	writer        io.Writer
+	client        *http.Client
+	headers       map[string]string
+	serviceName   string
+	batchSize     int
+	maxQueueSize  int
+	flushInterval time.Duration
+	exportTimeout time.Duration
+}
+
+type OTLPOption func(c *otlpConfig)
+
+// POST 到 OTLP/HTTP 的 endpoint, 空字符串使用 DefaultOTLPEndpoint
+func WithEndpoint(endpoint string) OTLPOption {
+	return func(c *otlpConfig) {
+		if endpoint == "" {
+			endpoint = DefaultOTLPEndpoint
+		}
+		c.endpoint = endpoint
+	}
+}
+
+// 追加写入文件, 每个批次一行
+func WithFile(path string) OTLPOption {
+	return func(c *otlpConfig) {
+		c.file = path
+	}
+}
+
+// 写入 w, 每个批次一行
+func WithWriter(w io.Writer) OTLPOption {
+	return func(c *otlpConfig) {
+		c.writer = w
+	}
+}
+
+func WithHTTPClient(client *http.Client) OTLPOption {
+	return func(c *otlpConfig) {
+		c.client = client
+	}
+}
+
+// 额外的 HTTP header, 例如鉴权
+func WithHeaders(headers map[string]string) OTLPOption {
+	return func(c *otlpConfig) {
+		c.headers = headers
+	}
+}
+
+// resource 的 service.name
+func WithServiceName(name string) OTLPOption {
+	return func(c *otlpConfig) {
+		c.serviceName = name
+	}
+}
+
+// 攒够 n 个 span 导出一次, 默认 512
+func WithBatchSize(n int) OTLPOption {
+	if n <= 0 {
+		panic("batch size must be positive")
+	}
+	return func(c *otlpConfig) {
+		c.batchSize = n
+	}
+}
+
+// 最多缓存 n 个未导出的 span, 超过时丢弃新的 span 并计数, 默认 2048
+func WithMaxQueueSize(n int) OTLPOption {
+	if n <= 0 {
+		panic("max queue size must be positive")
+	}
+	return func(c *otlpConfig) {
+		c.maxQueueSize = n
+	}
+}
+
+// 每次 POST 的超时, 默认 10s
+func WithExportTimeout(d time.Duration) OTLPOption {
+	if d <= 0 {
+		panic("export timeout must be positive")
+	}
+	return func(c *otlpConfig) {
+		c.exportTimeout = d
+	}
+}
+
+// 最多等待 d 导出一次, 默认 5s
+func WithFlushInterval(d time.Duration) OTLPOption {
+	if d <= 0 {
+		panic("flush interval must be positive")
+	}
+	return func(c *otlpConfig) {
+		c.flushInterval = d
+	}
+}
+
+// OTLPExporter 按 OTLP/JSON 格式批量导出 span
+type OTLPExporter struct {
+	cfg   otlpConfig
+	w     io.Writer
+	close func() error
+
+	mu      sync.Mutex
+	spans   []SpanData
+	dropped atomic.Uint64
+
+	sendMu   sync.Mutex // 保证批次按顺序写入
+	flush    chan struct{}
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewOTLPExporter 需要 WithEndpoint, WithFile 或 WithWriter 之一
+func NewOTLPExporter(opts ...OTLPOption) (*OTLPExporter, error) {
+	e := &OTLPExporter{
+		cfg: otlpConfig{
+			client:        http.DefaultClient,
+			serviceName:   "unknown_service",
+			batchSize:     512,
+			maxQueueSize:  2048,
+			flushInterval: 5 * time.Second,
+			exportTimeout: 10 * time.Second,
+		},
+		flush: make(chan struct{}, 1),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&e.cfg)
+	}
+
+	switch {
+	case e.cfg.writer != nil:
+		e.w = e.cfg.writer
+	case e.cfg.file != "":
+		f, err := os.OpenFile(e.cfg.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		e.w, e.close = f, f.Close
+	case e.cfg.endpoint == "":
+		return nil, errors.New("otlp exporter needs an endpoint, file or writer")
+	}
+
+	go e.run()
+	return e, nil
+}
+
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	select {
+	case <-e.quit:
+		return errors.New("otlp exporter is shut down")
+	default:
+	}
+
+	e.mu.Lock()
+	if n := e.cfg.maxQueueSize - len(e.spans); len(spans) > n {
+		if n < 0 {
+			n = 0
+		}
+		e.dropped.Add(uint64(len(spans) - n))
+		spans = spans[:n]
+	}
+	e.spans = append(e.spans, spans...)
+	full := len(e.spans) >= e.cfg.batchSize
+	e.mu.Unlock()
+	if full {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Dropped 缓存已满时丢弃的 span 数
+func (e *OTLPExporter) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+// Flush 立即导出缓存的 span
+func (e *OTLPExporter) Flush(ctx context.Context) error {
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+
+	var err error
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > e.cfg.batchSize {
+			n = e.cfg.batchSize
+		}
+		if serr := e.send(ctx, spans[:n]); serr != nil && err == nil {
+			err = serr
+		}
+		spans = spans[n:]
+	}
+	return err
+}
+
+// Shutdown 导出剩余的 span, 可以重复调用
+// ctx 结束时不再等待正在进行的导出, 返回 ctx.Err()
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	var err error
+	e.stopOnce.Do(func() {
+		close(e.quit)
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			err = ctx.Err()
+			if e.close != nil {
+				go func() {
+					<-e.done
+					e.close()
+				}()
+			}
+			return
+		}
+
+		err = e.Flush(ctx)
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if e.close != nil {
+			if cerr := e.close(); err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.cfg.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.flush:
+		case <-e.quit:
+			return
+		}
+		if err := e.Flush(context.Background()); err != nil {
+			logger.Warn("export spans failed", zap.Error(err))
+		}
+	}
+}
+
+func (e *OTLPExporter) send(ctx context.Context, spans []SpanData) error {
+	data, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	e.sendMu.Lock()
+	defer e.sendMu.Unlock()
+	if e.w != nil {
+		_, err = e.w.Write(append(data, '\n'))
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.cfg.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp endpoint %s: %s", e.cfg.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// 以下为 OTLP/JSON 的 ExportTraceServiceRequest, id 使用 hex, 64 位整数使用字符串
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Flags             uint32         `json:"flags,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Links             []otlpLink     `json:"links,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpLink struct {
+	TraceID    string         `json:"traceId"`
+	SpanID     string         `json:"spanId"`
+	TraceState string         `json:"traceState,omitempty"`
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string    `json:"stringValue,omitempty"`
+	BoolValue   *bool      `json:"boolValue,omitempty"`
+	IntValue    *string    `json:"intValue,omitempty"`
+	DoubleValue *float64   `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArray `json:"arrayValue,omitempty"`
+}
+
+type otlpArray struct {
+	Values []otlpValue `json:"values"`
+}
+
+func (e *OTLPExporter) request(spans []SpanData) otlpRequest {
+	list := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			TraceState:        s.TraceState,
+			Flags:             uint32(s.Flags),
+			Name:              s.Name,
+			Kind:              int(s.Kind),
+			StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+			Attributes:        otlpAttributes(codeAttributes(s)),
+			Status:            otlpStatus{Code: int(s.Status.Code), Message: s.Status.Message},
+		}
+		for _, ev := range s.Events {
+			span.Events = append(span.Events, otlpEvent{
+				TimeUnixNano: strconv.FormatInt(ev.Time.UnixNano(), 10),
+				Name:         ev.Name,
+				Attributes:   otlpAttributes(ev.Attributes),
+			})
+		}
+		for _, link := range s.Links {
+			span.Links = append(span.Links, otlpLink{
+				TraceID:    link.SpanContext.TraceID.String(),
+				SpanID:     link.SpanContext.SpanID.String(),
+				TraceState: link.SpanContext.TraceState,
+				Attributes: otlpAttributes(link.Attributes),
+			})
+		}
+		if s.ParentSpanID.IsValid() {
+			span.ParentSpanID = s.ParentSpanID.String()
+		}
+		list = append(list, span)
+	}
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: otlpAttributes(map[string]interface{}{"service.name": e.cfg.serviceName}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: scopeName},
+				Spans: list,
+			}},
+		}},
+	}
+}
+
+// codeAttributes 按 OpenTelemetry 的约定加上开始 span 的位置
+func codeAttributes(s SpanData) map[string]interface{} {
+	if s.File == "" {
+		return s.Attributes
+	}
+	attrs := make(map[string]interface{}, len(s.Attributes)+2)
+	for k, v := range s.Attributes {
+		attrs[k] = v
+	}
+	attrs["code.filepath"] = s.File
+	attrs["code.lineno"] = s.Line
+	return attrs
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	list := make([]otlpKeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		list = append(list, otlpKeyValue{Key: k, Value: otlpValueOf(v)})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Key < list[j].Key
+	})
+	return list
+}
+
+func otlpValueOf(v interface{}) otlpValue {
+	var value otlpValue
+	switch v := v.(type) {
+	case string:
+		value.StringValue = &v
+	case bool:
+		value.BoolValue = &v
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		s := fmt.Sprint(v)
+		value.IntValue = &s
+	case float32:
+		f := float64(v)
+		value.DoubleValue = &f
+	case float64:
+		value.DoubleValue = &v
+	case []string:
+		array := &otlpArray{Values: make([]otlpValue, len(v))}
+		for i := range v {
+			array.Values[i].StringValue = &v[i]
+		}
+		value.ArrayValue = array
+	case time.Duration:
+		s := strconv.FormatInt(int64(v), 10)
+		value.IntValue = &s
+	default:
+		s := fmt.Sprint(v)
+		value.StringValue = &s
+	}
+	return value
+}
@@ -0,0 +1,204 @@
+package trace
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOTLPExporterHTTP(t *testing.T) {
+	var mu sync.Mutex
+	var requests []otlpRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if r.Header.Get("Authorization") != "token" {
+			t.Errorf("missing header")
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	e, err := NewOTLPExporter(
+		WithEndpoint(srv.URL),
+		WithHeaders(map[string]string{"Authorization": "token"}),
+		WithServiceName("test"),
+		WithBatchSize(2),
+		WithFlushInterval(time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetExporter(e)
+	defer SetExporter(nil)
+
+	root := New(context.Background(), nil)
+	child := StartTrace(root).AddAttribute("count", 3).AddAttribute("ok", true)
+	child.EndTrace()
+	root.EndTrace()
+	third := StartTrace(root)
+	third.EndTrace()
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var spans []otlpSpan
+	for _, req := range requests {
+		rs := req.ResourceSpans[0]
+		if v := rs.Resource.Attributes[0].Value.StringValue; v == nil || *v != "test" {
+			t.Errorf("service.name = %v", v)
+		}
+		spans = append(spans, rs.ScopeSpans[0].Spans...)
+	}
+	if len(requests) != 2 || len(spans) != 3 {
+		t.Fatalf("got %d requests with %d spans, want 2 with 3", len(requests), len(spans))
+	}
+
+	got := spans[0]
+	if got.TraceID != root.TraceID.String() || got.SpanID != child.Span.SpanID.String() ||
+		got.ParentSpanID != root.Span.SpanID.String() {
+		t.Errorf("child span = %+v", got)
+	}
+	if spans[1].ParentSpanID != "" {
+		t.Errorf("root span has parent %s", spans[1].ParentSpanID)
+	}
+	attrs := make(map[string]otlpValue)
+	for _, kv := range got.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if v := attrs["count"].IntValue; v == nil || *v != "3" {
+		t.Errorf("count = %v", v)
+	}
+	if v := attrs["ok"].BoolValue; v == nil || !*v {
+		t.Errorf("ok = %v", v)
+	}
+}
+
+func TestOTLPExporterFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	e, err := NewOTLPExporter(WithFile(path), WithFlushInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	traceCtx := New(context.Background(), nil)
+	e.ExportSpans(traceCtx, []SpanData{traceCtx.data(time.Now())})
+	time.Sleep(50 * time.Millisecond)
+	e.ExportSpans(traceCtx, []SpanData{traceCtx.data(time.Now())})
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.ExportSpans(traceCtx, nil); err == nil {
+		t.Error("export after shutdown should fail")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var req otlpRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			t.Fatal(err)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("got %d batches, want 2", lines)
+	}
+}
+
+func TestOTLPExporterError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	e, err := NewOTLPExporter(WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	traceCtx := New(context.Background(), nil)
+	e.ExportSpans(traceCtx, []SpanData{traceCtx.data(time.Now())})
+	if err := e.Shutdown(context.Background()); err == nil {
+		t.Error("expected error from unavailable endpoint")
+	}
+
+	if _, err := NewOTLPExporter(); err == nil {
+		t.Error("expected error without output")
+	}
+}
+
+// collector 不响应时, 导出按超时失败, Shutdown 按 ctx 返回, 缓存有上限
+func TestOTLPExporterHung(t *testing.T) {
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stop:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(stop)
+
+	e, err := NewOTLPExporter(WithEndpoint(srv.URL), WithBatchSize(5), WithMaxQueueSize(10),
+		WithExportTimeout(50*time.Millisecond), WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	traceCtx := New(context.Background(), nil)
+	span := traceCtx.data(time.Now())
+	spans := make([]SpanData, 25)
+	for i := range spans {
+		spans[i] = span
+	}
+	e.ExportSpans(traceCtx, spans[:4])
+	e.ExportSpans(traceCtx, spans[4:])
+	if n := e.Dropped(); n != 15 {
+		t.Errorf("Dropped() = %d, want 15", n)
+	}
+
+	start := time.Now()
+	if err := e.Flush(context.Background()); err == nil {
+		t.Error("expected timeout from hung collector")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Flush() took %v", elapsed)
+	}
+
+	// 导出的超时大于 Shutdown 的 ctx, Shutdown 不应等待导出
+	e, err = NewOTLPExporter(WithEndpoint(srv.URL), WithBatchSize(1), WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.ExportSpans(traceCtx, spans[:1])
+	time.Sleep(50 * time.Millisecond) // 等待 run 开始导出
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := e.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown() took %v", elapsed)
+	}
+}
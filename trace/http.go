@@ -0,0 +1,208 @@
+package trace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// http span 的属性名
+const (
+	AttrHTTPMethod     = "http.method"
+	AttrHTTPRoute      = "http.route"
+	AttrHTTPTarget     = "http.target"
+	AttrHTTPURL        = "http.url"
+	AttrHTTPHost       = "http.host"
+	AttrHTTPStatusCode = "http.status_code"
+	AttrHTTPLatency    = "http.latency"
+)
+
+type httpConfig struct {
+	route func(r *http.Request) string
+}
+
+type HTTPOption func(c *httpConfig)
+
+// 取得请求的路由模板, 用于 span 的名称和 http.route
+// 没有设置时 span 只以请求方法命名, 避免路径中的 id 使名称的取值过多
+func WithRoute(route func(r *http.Request) string) HTTPOption {
+	return func(c *httpConfig) {
+		c.route = route
+	}
+}
+
+// Middleware 延续请求头中的追踪, 为每个请求开始一个 server span
+// span 放在 r.Context() 中, handler 中可以用 StartTrace(r.Context()) 开始子 span
+func Middleware(next http.Handler, opts ...HTTPOption) http.Handler {
+	var cfg httpConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		name := r.Method
+		attrs := []Attribute{
+			String(AttrHTTPMethod, r.Method),
+			String(AttrHTTPTarget, r.URL.RequestURI()),
+		}
+		if cfg.route != nil {
+			route := cfg.route(r)
+			name += " " + route
+			attrs = append(attrs, String(AttrHTTPRoute, route))
+		}
+		span := StartSpan(Extract(r.Context(), HeaderCarrier(r.Header)), name,
+			WithKind(SpanKindServer),
+			WithAttributes(attrs...),
+		)
+
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			x := recover()
+			status := rw.status
+			if x != nil {
+				status = http.StatusInternalServerError
+				span.RecordError(fmt.Errorf("panic: %v", x))
+			} else if status == 0 {
+				status = http.StatusOK
+			}
+			endHTTPSpan(span, status, start, http.StatusInternalServerError)
+			if x != nil {
+				panic(x)
+			}
+		}()
+		next.ServeHTTP(rw, r.WithContext(span))
+	})
+}
+
+// Transport 为每个请求开始一个 client span, 并把追踪写入请求头
+// span 在响应 body 读完或者关闭时结束, 调用方需要关闭 body
+type Transport struct {
+	Base http.RoundTripper // nil 使用 http.DefaultTransport
+}
+
+// NewTransport base 为 nil 时使用 http.DefaultTransport
+func NewTransport(base http.RoundTripper) *Transport {
+	return &Transport{Base: base}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	start := time.Now()
+	span := StartSpan(req.Context(), "HTTP "+req.Method,
+		WithKind(SpanKindClient),
+		WithAttributes(
+			String(AttrHTTPMethod, req.Method),
+			String(AttrHTTPURL, req.URL.String()),
+			String(AttrHTTPHost, req.URL.Host),
+		),
+	)
+
+	// RoundTripper 不能修改原来的请求
+	req = req.Clone(span)
+	Inject(span, HeaderCarrier(req.Header))
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		endHTTPSpan(span, 0, start, 0)
+		return nil, err
+	}
+	// 101 的 body 可写, 不能包装
+	if resp.Body == nil || resp.Body == http.NoBody || resp.StatusCode == http.StatusSwitchingProtocols {
+		endHTTPSpan(span, resp.StatusCode, start, http.StatusBadRequest)
+		return resp, nil
+	}
+	resp.Body = &spanBody{ReadCloser: resp.Body, span: span, status: resp.StatusCode, start: start}
+	return resp, nil
+}
+
+// spanBody 读到 EOF, 读取失败或者关闭时结束 client span, 延迟包括读取 body 的时间
+type spanBody struct {
+	io.ReadCloser
+	span   *TraceCtx
+	status int
+	start  time.Time
+	once   sync.Once
+}
+
+func (b *spanBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		if err != io.EOF {
+			b.span.RecordError(err)
+		}
+		b.end()
+	}
+	return n, err
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.end()
+	return err
+}
+
+func (b *spanBody) end() {
+	b.once.Do(func() {
+		endHTTPSpan(b.span, b.status, b.start, http.StatusBadRequest)
+	})
+}
+
+// endHTTPSpan 状态码不小于 errorFrom 时标记为失败
+func endHTTPSpan(span *TraceCtx, status int, start time.Time, errorFrom int) {
+	if status != 0 {
+		span.SetAttributes(Int(AttrHTTPStatusCode, status))
+		if errorFrom > 0 && status >= errorFrom {
+			span.setError(http.StatusText(status))
+		}
+	}
+	span.SetAttributes(Duration(AttrHTTPLatency, time.Since(start)))
+	span.EndTrace()
+}
+
+// responseWriter 记录状态码
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("response writer does not implement http.Hijacker")
+}
+
+// Unwrap 用于 http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,168 @@
+package trace
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func findSpan(r *SpanRecorder, kind SpanKind, key string, value interface{}) *SpanData {
+	for _, s := range r.ByAttribute(key, value) {
+		if s.Kind == kind {
+			return &s
+		}
+	}
+	return nil
+}
+
+func TestHTTP(t *testing.T) {
+	rec := NewSpanRecorder(0)
+	SetExporter(rec)
+	defer SetExporter(nil)
+
+	backend := httptest.NewServer(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/users/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		panic("boom")
+	}), WithRoute(func(r *http.Request) string {
+		if strings.HasPrefix(r.URL.Path, "/users/") {
+			return "/users/{id}"
+		}
+		return r.URL.Path
+	})))
+	defer backend.Close()
+
+	client := &http.Client{Transport: NewTransport(nil)}
+	frontend := httptest.NewServer(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, backend.URL+"/users/42", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+		w.Write([]byte("ok"))
+	})))
+	defer frontend.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, frontend.URL+"/home?x=1", nil)
+	parent := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Flags: FlagsSampled}
+	Inject(context.WithValue(context.Background(), remoteKey{}, parent), HeaderCarrier(req.Header))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	server := findSpan(rec, SpanKindServer, AttrHTTPTarget, "/home?x=1")
+	clientSpan := findSpan(rec, SpanKindClient, AttrHTTPURL, backend.URL+"/users/42")
+	inner := findSpan(rec, SpanKindServer, AttrHTTPRoute, "/users/{id}")
+	if server == nil || clientSpan == nil || inner == nil {
+		t.Fatalf("spans = %+v", rec.Spans())
+	}
+
+	// 上游 -> frontend server -> client -> backend server
+	if server.TraceID != parent.TraceID || server.ParentSpanID != parent.SpanID {
+		t.Errorf("server span did not continue the incoming trace")
+	}
+	if clientSpan.TraceID != parent.TraceID || clientSpan.ParentSpanID != server.SpanID {
+		t.Errorf("client span parent = %s, want %s", clientSpan.ParentSpanID, server.SpanID)
+	}
+	if inner.TraceID != parent.TraceID || inner.ParentSpanID != clientSpan.SpanID {
+		t.Errorf("backend span parent = %s, want %s", inner.ParentSpanID, clientSpan.SpanID)
+	}
+
+	if server.Attributes[AttrHTTPStatusCode] != int64(http.StatusOK) || server.Attributes[AttrHTTPTarget] != "/home?x=1" ||
+		server.Status.Code != StatusUnset || server.Attributes[AttrHTTPLatency] == nil {
+		t.Errorf("server span = %+v", server)
+	}
+	// 没有 WithRoute 时只以方法命名, 不记录 http.route
+	if _, ok := server.Attributes[AttrHTTPRoute]; server.Name != "GET" || ok {
+		t.Errorf("server span name = %q, attributes = %v", server.Name, server.Attributes)
+	}
+	if inner.Name != "GET /users/{id}" {
+		t.Errorf("backend span name = %q", inner.Name)
+	}
+	// 404 对 client 是失败, 对 server 不是
+	if clientSpan.Attributes[AttrHTTPStatusCode] != int64(http.StatusNotFound) || clientSpan.Status.Code != StatusError {
+		t.Errorf("client span = %+v", clientSpan)
+	}
+	if inner.Status.Code != StatusUnset {
+		t.Errorf("backend span = %+v", inner)
+	}
+
+	// handler panic 记录为 500
+	resp, err = client.Get(backend.URL + "/panic")
+	if err == nil {
+		resp.Body.Close()
+	}
+	panicked := findSpan(rec, SpanKindServer, AttrHTTPRoute, "/panic")
+	if panicked == nil || panicked.Attributes[AttrHTTPStatusCode] != int64(http.StatusInternalServerError) ||
+		panicked.Status.Code != StatusError || len(panicked.Events) != 1 {
+		t.Errorf("panic span = %+v", panicked)
+	}
+}
+
+// client span 在 body 读完时结束, 延迟包括读取 body 的时间
+func TestTransportBody(t *testing.T) {
+	rec := NewSpanRecorder(0)
+	SetExporter(rec)
+	defer SetExporter(nil)
+
+	const delay = 50 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/short" {
+			// body 比 Content-Length 短, 读取失败
+			w.Header().Set("Content-Length", "10")
+			w.Write([]byte("abc"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(delay)
+		w.Write([]byte("done"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewTransport(nil)}
+	resp, err := client.Get(server.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if span := findSpan(rec, SpanKindClient, AttrHTTPURL, server.URL+"/slow"); span != nil {
+		t.Errorf("client span ended before body was read: %+v", span)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	span := findSpan(rec, SpanKindClient, AttrHTTPURL, server.URL+"/slow")
+	if span == nil {
+		t.Fatalf("client span not ended after EOF, spans = %+v", rec.Spans())
+	}
+	if latency, _ := span.Attributes[AttrHTTPLatency].(time.Duration); latency < delay {
+		t.Errorf("client span latency = %v, want >= %v", latency, delay)
+	}
+	resp.Body.Close()
+	if n := len(rec.ByAttribute(AttrHTTPURL, server.URL+"/slow")); n != 1 {
+		t.Errorf("client span exported %d times", n)
+	}
+
+	resp, err = client.Get(server.URL + "/short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Fatal("expected body read error")
+	}
+	resp.Body.Close()
+	span = findSpan(rec, SpanKindClient, AttrHTTPURL, server.URL+"/short")
+	if span == nil || span.Status.Code != StatusError || len(span.Events) != 1 {
+		t.Errorf("client span = %+v", span)
+	}
+}
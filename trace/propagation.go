@@ -0,0 +1,192 @@
+package trace
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+	"net/http"
+	"strings"
+)
+
+// W3C Trace Context 的 header
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+const FlagsSampled = byte(0x01)
+
+// TraceID 128 位, 全 0 无效
+type TraceID [16]byte
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID 64 位, 全 0 无效
+type SpanID [8]byte
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// id 使用 math/rand, 比 crypto/rand 快得多, 未采样的 span 也需要生成
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+// SpanContext 跨进程传递的追踪信息
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+	Remote     bool // 从 Carrier 中解析得到
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagsSampled != 0
+}
+
+// Carrier 读写传递追踪信息的键值对
+type Carrier interface {
+	Get(key string) string
+	Set(key, value string)
+}
+
+// HeaderCarrier 基于 http.Header
+type HeaderCarrier http.Header
+
+func (c HeaderCarrier) Get(key string) string {
+	return http.Header(c).Get(key)
+}
+
+func (c HeaderCarrier) Set(key, value string) {
+	http.Header(c).Set(key, value)
+}
+
+// MapCarrier 基于 map, 适用于消息队列等, key 区分大小写
+type MapCarrier map[string]string
+
+func (c MapCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c MapCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+type remoteKey struct{}
+
+// Inject 把 ctx 中的追踪信息写入 carrier
+func Inject(ctx context.Context, carrier Carrier) {
+	sc, ok := spanContextFrom(ctx)
+	if !ok {
+		return
+	}
+	carrier.Set(TraceparentHeader, formatTraceparent(sc))
+	if sc.TraceState != "" {
+		carrier.Set(TracestateHeader, sc.TraceState)
+	}
+}
+
+// Extract 从 carrier 解析追踪信息, 之后的 StartTrace 会延续这个追踪
+// 解析失败时返回原来的 ctx
+func Extract(ctx context.Context, carrier Carrier) context.Context {
+	sc, ok := parseTraceparent(carrier.Get(TraceparentHeader))
+	if !ok {
+		return ctx
+	}
+	sc.TraceState = strings.TrimSpace(carrier.Get(TracestateHeader))
+	sc.Remote = true
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFrom 返回 ctx 中的追踪信息
+func SpanContextFrom(ctx context.Context) (SpanContext, bool) {
+	return spanContextFrom(ctx)
+}
+
+func spanContextFrom(ctx context.Context) (SpanContext, bool) {
+	if traceCtx := FromContext(ctx); traceCtx != nil {
+		return traceCtx.SpanContext(), true
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok
+}
+
+// 格式为 version-traceid-spanid-flags
+func formatTraceparent(sc SpanContext) string {
+	var b [55]byte
+	b[0], b[1], b[2] = '0', '0', '-'
+	hex.Encode(b[3:35], sc.TraceID[:])
+	b[35] = '-'
+	hex.Encode(b[36:52], sc.SpanID[:])
+	b[52] = '-'
+	hex.Encode(b[53:55], []byte{sc.Flags})
+	return string(b[:])
+}
+
+func parseTraceparent(s string) (sc SpanContext, ok bool) {
+	s = strings.TrimSpace(s)
+	// 版本 00 长度固定, 更高的版本可能在后面追加字段
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, false
+	}
+	var version [1]byte
+	if !decodeHex(version[:], s[0:2]) || version[0] == 0xff {
+		return sc, false
+	}
+	if version[0] == 0 && len(s) != 55 {
+		return sc, false
+	}
+	if len(s) > 55 && s[55] != '-' {
+		return sc, false
+	}
+
+	var flags [1]byte
+	if !decodeHex(sc.TraceID[:], s[3:35]) ||
+		!decodeHex(sc.SpanID[:], s[36:52]) ||
+		!decodeHex(flags[:], s[53:55]) {
+		return sc, false
+	}
+	sc.Flags = flags[0]
+	return sc, sc.IsValid()
+}
+
+// decodeHex 只接受小写
+func decodeHex(dst []byte, s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; 'A' <= c && c <= 'F' {
+			return false
+		}
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
@@ -0,0 +1,72 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestTraceparent(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	header := http.Header{}
+	header.Set("Traceparent", parent)
+	header.Set("Tracestate", "congo=t61rcWkgMzE")
+
+	ctx := Extract(context.Background(), HeaderCarrier(header))
+	traceCtx := StartTrace(ctx)
+	if got := traceCtx.TraceID.String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("TraceID = %s", got)
+	}
+	if got := traceCtx.Span.ParentSpanID.String(); got != "00f067aa0ba902b7" {
+		t.Errorf("ParentSpanID = %s", got)
+	}
+	if traceCtx.Span.SpanID == traceCtx.Span.ParentSpanID {
+		t.Error("span id not regenerated")
+	}
+
+	out := MapCarrier{}
+	Inject(traceCtx, out)
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + traceCtx.Span.SpanID.String() + "-01"
+	if out[TraceparentHeader] != want {
+		t.Errorf("traceparent = %s, want %s", out[TraceparentHeader], want)
+	}
+	if out[TracestateHeader] != "congo=t61rcWkgMzE" {
+		t.Errorf("tracestate = %s", out[TracestateHeader])
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	cases := []struct {
+		s  string
+		ok bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if _, ok := parseTraceparent(c.s); ok != c.ok {
+			t.Errorf("parse(%q) ok = %v, want %v", c.s, ok, c.ok)
+		}
+	}
+}
+
+func TestNewTrace(t *testing.T) {
+	traceCtx := StartTrace(context.Background())
+	if !traceCtx.TraceID.IsValid() || !traceCtx.Span.SpanID.IsValid() {
+		t.Errorf("invalid ids %s %s", traceCtx.TraceID, traceCtx.Span.SpanID)
+	}
+	if traceCtx.Span.ParentSpanID.IsValid() {
+		t.Errorf("root span has parent %s", traceCtx.Span.ParentSpanID)
+	}
+	if other := StartTrace(context.Background()); other.TraceID == traceCtx.TraceID {
+		t.Error("trace id reused")
+	}
+}
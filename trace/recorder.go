@@ -0,0 +1,279 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanRecorder 在内存中保存结束的 span, 用于单元测试和调试页面
+type SpanRecorder struct {
+	mu    sync.Mutex
+	limit int
+	spans []SpanData
+}
+
+// NewSpanRecorder 最多保存 limit 个 span, 超过时丢弃最早的, limit <= 0 不限制
+func NewSpanRecorder(limit int) *SpanRecorder {
+	return &SpanRecorder{limit: limit}
+}
+
+func (r *SpanRecorder) ExportSpans(ctx context.Context, spans []SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	if r.limit > 0 && len(r.spans) > r.limit {
+		n := copy(r.spans, r.spans[len(r.spans)-r.limit:])
+		r.spans = r.spans[:n]
+	}
+	return nil
+}
+
+func (r *SpanRecorder) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// Reset 清空已经保存的 span
+func (r *SpanRecorder) Reset() {
+	r.mu.Lock()
+	r.spans = nil
+	r.mu.Unlock()
+}
+
+// Spans 按结束的顺序返回所有 span
+func (r *SpanRecorder) Spans() []SpanData {
+	return r.Filter(func(SpanData) bool { return true })
+}
+
+// Filter 返回满足 f 的 span
+func (r *SpanRecorder) Filter(f func(s SpanData) bool) []SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var list []SpanData
+	for _, s := range r.spans {
+		if f(s) {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func (r *SpanRecorder) ByTrace(id TraceID) []SpanData {
+	return r.Filter(func(s SpanData) bool { return s.TraceID == id })
+}
+
+func (r *SpanRecorder) ByName(name string) []SpanData {
+	return r.Filter(func(s SpanData) bool { return s.Name == name })
+}
+
+// ByAttribute value 需要与保存的类型一致, 例如 Int 写入的为 int64
+func (r *SpanRecorder) ByAttribute(key string, value interface{}) []SpanData {
+	return r.Filter(func(s SpanData) bool {
+		v, ok := s.Attributes[key]
+		return ok && v == value
+	})
+}
+
+// Span 按 id 查找
+func (r *SpanRecorder) Span(id SpanID) (SpanData, bool) {
+	list := r.Filter(func(s SpanData) bool { return s.SpanID == id })
+	if len(list) == 0 {
+		return SpanData{}, false
+	}
+	return list[0], true
+}
+
+// Traces 最近结束的 trace, 最新的在前
+func (r *SpanRecorder) Traces() []TraceID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen := make(map[TraceID]bool)
+	var ids []TraceID
+	for i := len(r.spans) - 1; i >= 0; i-- {
+		if id := r.spans[i].TraceID; !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// SpanNode span 树的节点
+type SpanNode struct {
+	SpanData
+	Children []*SpanNode
+}
+
+// Tree 还原 trace 的 span 树, 父 span 不在记录中的作为根, 兄弟按开始时间排序
+func (r *SpanRecorder) Tree(id TraceID) []*SpanNode {
+	spans := r.ByTrace(id)
+	nodes := make(map[SpanID]*SpanNode, len(spans))
+	for _, s := range spans {
+		nodes[s.SpanID] = &SpanNode{SpanData: s}
+	}
+	var roots []*SpanNode
+	for _, s := range spans {
+		node := nodes[s.SpanID]
+		if parent, ok := nodes[s.ParentSpanID]; ok && parent != node {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	sortNodes(roots)
+	return roots
+}
+
+func sortNodes(nodes []*SpanNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].StartTime.Before(nodes[j].StartTime)
+	})
+	for _, node := range nodes {
+		sortNodes(node.Children)
+	}
+}
+
+// Handler 以瀑布图显示最近的 trace, ?trace=<id> 只显示一个, ?n= 指定数量, 默认 20
+func (r *SpanRecorder) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ids := r.Traces()
+		if s := req.URL.Query().Get("trace"); s != "" {
+			ids = ids[:0]
+			for _, id := range r.Traces() {
+				if id.String() == s {
+					ids = append(ids, id)
+				}
+			}
+		}
+		n := 20
+		if v, err := strconv.Atoi(req.URL.Query().Get("n")); err == nil && v > 0 {
+			n = v
+		}
+		if len(ids) > n {
+			ids = ids[:n]
+		}
+
+		var page []waterfall
+		for _, id := range ids {
+			page = append(page, newWaterfall(id, r.ByTrace(id), r.Tree(id)))
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		waterfallTemplate.Execute(w, page)
+	})
+}
+
+// waterfall 一个 trace 的瀑布图
+type waterfall struct {
+	TraceID  string
+	Start    time.Time
+	Duration time.Duration
+	Rows     []waterfallRow
+}
+
+type waterfallRow struct {
+	Depth    int
+	Name     string
+	SpanID   string
+	Duration time.Duration
+	Left     float64 // 相对 trace 开始的百分比
+	Width    float64
+	Error    bool
+	Status   string
+	Attrs    string
+}
+
+func newWaterfall(id TraceID, spans []SpanData, roots []*SpanNode) waterfall {
+	var start, end time.Time
+	for _, s := range spans {
+		if start.IsZero() || s.StartTime.Before(start) {
+			start = s.StartTime
+		}
+		if s.EndTime.After(end) {
+			end = s.EndTime
+		}
+	}
+	wf := waterfall{TraceID: id.String(), Start: start, Duration: end.Sub(start)}
+	total := float64(wf.Duration)
+	if total <= 0 {
+		total = 1
+	}
+
+	var walk func(nodes []*SpanNode, depth int)
+	walk = func(nodes []*SpanNode, depth int) {
+		for _, node := range nodes {
+			d := node.EndTime.Sub(node.StartTime)
+			wf.Rows = append(wf.Rows, waterfallRow{
+				Depth:    depth,
+				Name:     node.Name,
+				SpanID:   node.SpanID.String(),
+				Duration: d,
+				Left:     float64(node.StartTime.Sub(start)) * 100 / total,
+				Width:    float64(d) * 100 / total,
+				Error:    node.Status.Code == StatusError,
+				Status:   node.Status.Message,
+				Attrs:    formatAttrs(node.Attributes),
+			})
+			walk(node.Children, depth+1)
+		}
+	}
+	walk(roots, 0)
+	return wf
+}
+
+func formatAttrs(attrs map[string]interface{}) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%v\n", k, attrs[k])
+	}
+	return b.String()
+}
+
+var waterfallTemplate = template.Must(template.New("waterfall").Funcs(template.FuncMap{
+	"indent": func(depth int) int { return depth * 16 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>traces</title>
+<style>
+body { font: 13px monospace; margin: 16px; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 24px; }
+td { padding: 2px 6px; white-space: nowrap; }
+td.bar { width: 60%; }
+.bar div { height: 12px; background: #4a90d9; min-width: 1px; position: relative; }
+tr.error .bar div { background: #d9534f; }
+tr.error td.name { color: #d9534f; }
+h3 a { color: inherit; }
+</style>
+</head>
+<body>
+{{range .}}
+<h3><a href="?trace={{.TraceID}}">{{.TraceID}}</a> {{.Start.Format "15:04:05.000"}} {{.Duration}}</h3>
+<table>
+{{range .Rows}}
+<tr{{if .Error}} class="error"{{end}} title="{{.Attrs}}">
+<td class="name" style="padding-left: {{indent .Depth}}px">{{.Name}}</td>
+<td>{{.SpanID}}</td>
+<td>{{.Duration}}</td>
+<td class="bar"><div style="left: {{printf "%.2f" .Left}}%; width: {{printf "%.2f" .Width}}%"></div></td>
+<td>{{.Status}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p>no traces</p>
+{{end}}
+</body>
+</html>
+`))
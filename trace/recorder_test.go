@@ -0,0 +1,82 @@
+package trace
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSpanRecorder(t *testing.T) {
+	rec := NewSpanRecorder(0)
+	SetExporter(rec)
+	defer SetExporter(nil)
+
+	root := New(context.Background(), nil)
+	a := StartTrace(root)
+	a1 := StartTrace(a).SetAttributes(String("db", "users"))
+	a1.RecordError(io.EOF)
+	a1.EndTrace()
+	a.EndTrace()
+	b := StartTrace(root)
+	b.EndTrace()
+	root.EndTrace()
+	other := New(context.Background(), nil)
+	other.EndTrace()
+
+	if n := len(rec.Spans()); n != 5 {
+		t.Fatalf("recorded %d spans", n)
+	}
+	if n := len(rec.ByTrace(root.TraceID)); n != 4 {
+		t.Errorf("ByTrace = %d spans", n)
+	}
+	if got := rec.ByAttribute("db", "users"); len(got) != 1 || got[0].SpanID != a1.Span.SpanID {
+		t.Errorf("ByAttribute = %+v", got)
+	}
+	if got := rec.ByName("github.com/xsean2020/misc/trace.TestSpanRecorder"); len(got) != 5 {
+		t.Errorf("ByName = %d spans", len(got))
+	}
+	if ids := rec.Traces(); len(ids) != 2 || ids[0] != other.TraceID || ids[1] != root.TraceID {
+		t.Errorf("Traces = %v", ids)
+	}
+
+	roots := rec.Tree(root.TraceID)
+	if len(roots) != 1 || roots[0].SpanID != root.Span.SpanID {
+		t.Fatalf("roots = %+v", roots)
+	}
+	children := roots[0].Children
+	if len(children) != 2 || children[0].SpanID != a.Span.SpanID || children[1].SpanID != b.Span.SpanID {
+		t.Fatalf("children = %+v", children)
+	}
+	if len(children[0].Children) != 1 || children[0].Children[0].SpanID != a1.Span.SpanID {
+		t.Errorf("grandchildren = %+v", children[0].Children)
+	}
+
+	// 调试页面
+	w := httptest.NewRecorder()
+	rec.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/?trace="+root.TraceID.String(), nil))
+	body := w.Body.String()
+	if !strings.Contains(body, root.TraceID.String()) || strings.Contains(body, other.TraceID.String()) {
+		t.Errorf("page does not show only the selected trace")
+	}
+	if strings.Count(body, "<tr") != 4 || !strings.Contains(body, `class="error"`) {
+		t.Errorf("page = %s", body)
+	}
+
+	rec.Reset()
+	if len(rec.Spans()) != 0 {
+		t.Error("Reset kept spans")
+	}
+}
+
+func TestSpanRecorderLimit(t *testing.T) {
+	rec := NewSpanRecorder(3)
+	for i := 0; i < 5; i++ {
+		rec.ExportSpans(context.Background(), []SpanData{{Name: string(rune('a' + i))}})
+	}
+	spans := rec.Spans()
+	if len(spans) != 3 || spans[0].Name != "c" || spans[2].Name != "e" {
+		t.Errorf("spans = %+v", spans)
+	}
+}
@@ -0,0 +1,102 @@
+package trace
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+)
+
+// SamplingParams 采样时可用的信息
+type SamplingParams struct {
+	Parent  SpanContext // 无效表示根 span
+	TraceID TraceID
+}
+
+// Sampler 决定 span 是否采样, 需要并发安全
+type Sampler interface {
+	ShouldSample(p SamplingParams) bool
+}
+
+// SamplerFunc 把函数适配为 Sampler
+type SamplerFunc func(p SamplingParams) bool
+
+func (f SamplerFunc) ShouldSample(p SamplingParams) bool {
+	return f(p)
+}
+
+// 默认延续父 span 的决定, 根 span 全部采样
+var sampler Sampler = ParentBased(AlwaysSample())
+
+// SetSampler 设置全局的 Sampler, 只影响之后开始的 span
+func SetSampler(s Sampler) {
+	sampler = s
+}
+
+func AlwaysSample() Sampler {
+	return SamplerFunc(func(SamplingParams) bool { return true })
+}
+
+func NeverSample() Sampler {
+	return SamplerFunc(func(SamplingParams) bool { return false })
+}
+
+// TraceIDRatio 按 trace id 采样 fraction 的比例, 同一个 trace 在各个服务中的决定一致
+func TraceIDRatio(fraction float64) Sampler {
+	if fraction < 0 || fraction > 1 {
+		panic("sampling fraction must be in [0, 1]")
+	}
+	if fraction == 1 {
+		return AlwaysSample()
+	}
+	bound := uint64(fraction * (1 << 63))
+	return SamplerFunc(func(p SamplingParams) bool {
+		// 与 OpenTelemetry 一致, 取低 8 字节的高 63 位
+		return binary.BigEndian.Uint64(p.TraceID[8:16])>>1 < bound
+	})
+}
+
+// RateLimited 令牌桶, 每秒最多采样 perSecond 个 span, 允许 1 秒的突发
+func RateLimited(perSecond float64) Sampler {
+	if perSecond < 0 {
+		panic("sampling rate must not be negative")
+	}
+	capacity := math.Max(perSecond, 1)
+	return &tokenBucket{
+		rate:     perSecond,
+		capacity: capacity,
+		tokens:   perSecond,
+		last:     time.Now(),
+	}
+}
+
+type tokenBucket struct {
+	mu       sync.Mutex
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func (b *tokenBucket) ShouldSample(SamplingParams) bool {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// ParentBased 有父 span 时延续父 span 的决定, 根 span 由 root 决定
+func ParentBased(root Sampler) Sampler {
+	return SamplerFunc(func(p SamplingParams) bool {
+		if p.Parent.IsValid() {
+			return p.Parent.Sampled()
+		}
+		return root.ShouldSample(p)
+	})
+}
@@ -0,0 +1,118 @@
+package trace
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestTraceIDRatio(t *testing.T) {
+	const n = 10000
+	s := TraceIDRatio(0.25)
+	sampled := 0
+	for i := 0; i < n; i++ {
+		if s.ShouldSample(SamplingParams{TraceID: newTraceID()}) {
+			sampled++
+		}
+	}
+	if sampled < n/5 || sampled > n*3/10 {
+		t.Errorf("sampled %d of %d, want about %d", sampled, n, n/4)
+	}
+
+	id := newTraceID()
+	first := s.ShouldSample(SamplingParams{TraceID: id})
+	for i := 0; i < 10; i++ {
+		if s.ShouldSample(SamplingParams{TraceID: id}) != first {
+			t.Fatal("decision for the same trace id changed")
+		}
+	}
+	if TraceIDRatio(0).ShouldSample(SamplingParams{TraceID: id}) {
+		t.Error("ratio 0 sampled")
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	s := RateLimited(10)
+	sampled := 0
+	for i := 0; i < 100; i++ {
+		if s.ShouldSample(SamplingParams{}) {
+			sampled++
+		}
+	}
+	if sampled != 10 {
+		t.Errorf("burst sampled %d, want 10", sampled)
+	}
+	time.Sleep(250 * time.Millisecond)
+	sampled = 0
+	for i := 0; i < 100; i++ {
+		if s.ShouldSample(SamplingParams{}) {
+			sampled++
+		}
+	}
+	if sampled < 1 || sampled > 4 {
+		t.Errorf("sampled %d after 250ms, want about 2", sampled)
+	}
+	if RateLimited(0).ShouldSample(SamplingParams{}) {
+		t.Error("rate 0 sampled")
+	}
+}
+
+func TestParentBased(t *testing.T) {
+	s := ParentBased(NeverSample())
+	parent := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Flags: FlagsSampled}
+	if !s.ShouldSample(SamplingParams{Parent: parent}) {
+		t.Error("sampled parent not followed")
+	}
+	parent.Flags = 0
+	if s.ShouldSample(SamplingParams{Parent: parent}) {
+		t.Error("unsampled parent not followed")
+	}
+	if s.ShouldSample(SamplingParams{}) {
+		t.Error("root sampler not used")
+	}
+}
+
+func TestUnsampledSpan(t *testing.T) {
+	SetSampler(ParentBased(NeverSample()))
+	defer SetSampler(ParentBased(AlwaysSample()))
+
+	core, logs := observer.New(zap.InfoLevel)
+	root := New(context.Background(), zap.New(core))
+	child := StartTrace(root)
+	child.AddAttribute("k", "v").AddEvent("e", nil).RecordError(context.Canceled)
+	child.EndTrace()
+	root.EndTrace()
+
+	if root.Sampled() || child.Sampled() {
+		t.Error("span sampled by NeverSample")
+	}
+	if logs.Len() != 0 {
+		t.Errorf("unsampled spans logged %d entries", logs.Len())
+	}
+
+	// id 仍然传递
+	if child.TraceID != root.TraceID || child.Span.ParentSpanID != root.Span.SpanID {
+		t.Error("ids not propagated through unsampled spans")
+	}
+	out := MapCarrier{}
+	Inject(child, out)
+	want := "00-" + root.TraceID.String() + "-" + child.Span.SpanID.String() + "-00"
+	if out[TraceparentHeader] != want {
+		t.Errorf("traceparent = %s, want %s", out[TraceparentHeader], want)
+	}
+}
+
+func BenchmarkStartTraceUnsampled(b *testing.B) {
+	SetSampler(NeverSample())
+	defer SetSampler(ParentBased(AlwaysSample()))
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		traceCtx := StartTrace(ctx)
+		traceCtx.AddAttribute("k", i)
+		traceCtx.EndTrace()
+	}
+}
@@ -2,26 +2,32 @@ package trace
 
 import (
 	"context"
-	"fmt"
-	"runtime"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/xsean2020/misc"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"golang.org/x/sync/singleflight"
 )
 
-// funcNameCache 缓存函数名称，避免重复调用 runtime.FuncForPC
-var funcNameCache = make(map[uintptr]string)
-var funcNameCacheMutex sync.RWMutex
-var singleFlight singleflight.Group
-
 // Span 用于表示追踪的一个节点
+// 通过 TraceCtx 的方法修改是并发安全的, 直接读取字段需要在 EndTrace 之后
 type Span struct {
-	SpanID       int
-	ParentSpanID int
+	Name         string
+	File         string // 开始 span 的位置
+	Line         int
+	SpanID       SpanID
+	ParentSpanID SpanID // 根 span 为全 0
 	Attributes   map[string]interface{}
+	Events       []Event
+	Status       Status
+	Kind         SpanKind
+	Links        []Link
+
+	mu    sync.Mutex
+	typed []Attribute // SetAttributes 写入的属性
+	ended bool        // 结束后的修改被忽略
 }
 
 // TraceCtx 表示一个完整的追踪上下文
@@ -29,7 +35,9 @@ type TraceCtx struct {
 	context.Context
 	level     zapcore.Level
 	logger    *zap.Logger
-	TraceID   string
+	TraceID   TraceID
+	Flags     byte   // W3C trace-flags
+	State     string // W3C tracestate, 原样传递
 	Span      *Span
 	StartTime time.Time
 }
@@ -53,116 +61,303 @@ func Init(l *zap.Logger) {
 	logger = l
 }
 
-// getFuncName 获取调用 StartTrace 的函数名称，使用缓存来优化性能
-func getFuncName() string {
-	pc, _, _, ok := runtime.Caller(2)
-	if !ok {
-		return "unknown"
+type spanConfig struct {
+	skip    int
+	kind    SpanKind
+	attrs   []Attribute
+	links   []Link
+	newRoot bool
+}
+
+type SpanOption func(c *spanConfig)
+
+// 跳过 skip 层调用者, 用于封装 StartSpan 的函数, 名称和位置取自封装函数的调用者
+func WithCallerSkip(skip int) SpanOption {
+	return func(c *spanConfig) {
+		c.skip += skip
 	}
+}
 
-	funcNameCacheMutex.RLock()
-	name, found := funcNameCache[pc]
-	funcNameCacheMutex.RUnlock()
-	if found {
-		return name
+func WithKind(kind SpanKind) SpanOption {
+	return func(c *spanConfig) {
+		c.kind = kind
 	}
+}
 
-	singleFlight.Do(fmt.Sprint(pc), func() (interface{}, error) {
-		fn := runtime.FuncForPC(pc)
-		if fn != nil {
-			name = fn.Name()
-		} else {
-			name = "unknown"
-		}
-		funcNameCacheMutex.Lock()
-		funcNameCache[pc] = name
-		funcNameCacheMutex.Unlock()
-		return nil, nil
-	})
-	return name
+func WithAttributes(attrs ...Attribute) SpanOption {
+	return func(c *spanConfig) {
+		c.attrs = append(c.attrs, attrs...)
+	}
 }
 
-func New(cxt context.Context, logger *zap.Logger) *TraceCtx {
-	traceCtx := &TraceCtx{
-		logger:    logger,
-		level:     zapcore.InfoLevel,
-		Context:   ctx,
-		TraceID:   fmt.Sprintf("trace-%d", time.Now().UnixNano()),
-		StartTime: time.Now(),
-		Span: &Span{
-			SpanID: 1,
-			Attributes: map[string]interface{}{
-				"FuncName": funcName,
-			},
-		},
+// 指向其他 span, 见 LinkTo
+func WithLinks(links ...Link) SpanOption {
+	return func(c *spanConfig) {
+		c.links = append(c.links, links...)
 	}
-	return traceCtx
+}
+
+// 忽略 ctx 中的追踪开始新的追踪, 通常与 WithLinks 一起用于合并多个请求的批处理
+func WithNewRoot() SpanOption {
+	return func(c *spanConfig) {
+		c.newRoot = true
+	}
+}
 
+// New 开始一个新的追踪, 名称为调用者的函数名
+func New(ctx context.Context, logger *zap.Logger) *TraceCtx {
+	return startSpan(ctx, logger, SpanContext{}, "", &spanConfig{skip: 3, kind: SpanKindInternal})
 }
 
 // StartTrace 返回 TraceCtx，且实现了 context.Context 接口，能自动管理 Trace 和 Span
+// 名称为调用者的函数名, 同 StartSpan(ctx, "")
 func StartTrace(ctx context.Context) *TraceCtx {
-	funcName := getFuncName()
-	// 如果 ctx 中没有 TraceCtx，则创建一个新的 TraceCtx
-	lastCtx, ok := ctx.Value("traceKey").(*TraceCtx)
-	if !ok {
-		return New(ctx, logger)
+	return startChild(ctx, "", nil)
+}
+
+// StartSpan 开始一个名为 name 的 span, name 为空时使用调用者的函数名
+// ctx 中没有 TraceCtx 时, 延续 Extract 得到的远端追踪, 否则开始新的追踪
+func StartSpan(ctx context.Context, name string, opts ...SpanOption) *TraceCtx {
+	return startChild(ctx, name, opts)
+}
+
+// startChild 只能由导出的函数直接调用, 以保证调用者的层数
+func startChild(ctx context.Context, name string, opts []SpanOption) *TraceCtx {
+	cfg := spanConfig{skip: 4, kind: SpanKindInternal}
+	for _, opt := range opts {
+		opt(&cfg)
 	}
+	l, parent := logger, SpanContext{}
 	// 基于父 TraceCtx 创建新的 TraceCtx
+	if lastCtx := FromContext(ctx); lastCtx != nil {
+		l, parent = lastCtx.logger, lastCtx.SpanContext()
+	} else {
+		parent, _ = ctx.Value(remoteKey{}).(SpanContext)
+	}
+	if cfg.newRoot {
+		parent = SpanContext{}
+	}
+	return startSpan(ctx, l, parent, name, &cfg)
+}
+
+// startSpan cfg.skip 为用户调用者相对 startSpan 的层数
+func startSpan(ctx context.Context, logger *zap.Logger, parent SpanContext, name string, cfg *spanConfig) *TraceCtx {
+	traceCtx := newTraceCtx(ctx, logger, parent)
+	// 未采样的 span 不需要名称和位置
+	if !traceCtx.Sampled() {
+		return traceCtx
+	}
+
+	span := traceCtx.Span
+	span.Name = name
+	// misc.Caller 按 pc 缓存了 Frame
+	if frame, ok := misc.Caller(cfg.skip); ok {
+		span.File, span.Line = frame.File, frame.Line
+		if span.Name == "" {
+			span.Name = frame.Function
+		}
+	}
+	if span.Name == "" {
+		span.Name = "unknown"
+	}
+	span.Kind = cfg.kind
+	span.Links = cfg.links
+	for _, a := range cfg.attrs {
+		span.setTyped(a)
+	}
+	return traceCtx
+}
+
+// newTraceCtx parent 无效时开始新的追踪
+// 未采样时只保留用于传递的 id, 其他操作都是空操作
+func newTraceCtx(ctx context.Context, logger *zap.Logger, parent SpanContext) *TraceCtx {
 	traceCtx := &TraceCtx{
-		Context:   ctx,
-		level:     zapcore.InfoLevel,
-		logger:    lastCtx.Logger,
-		TraceID:   lastCtx.TraceID,
-		StartTime: time.Now(),
+		Context: ctx,
+		level:   zapcore.InfoLevel,
+		logger:  logger,
+		TraceID: parent.TraceID,
+		Flags:   parent.Flags,
+		State:   parent.TraceState,
 		Span: &Span{
-			ParentSpanID: lastCtx.Span.SpanID,
-			SpanID:       lastCtx.Span.SpanID + 1,
-			Attributes: map[string]interface{}{
-				"FuncName": funcName,
-			},
+			ParentSpanID: parent.SpanID,
+			SpanID:       newSpanID(),
 		},
 	}
+	// 随机的 id 与父 span 相同时重新生成
+	for traceCtx.Span.SpanID == parent.SpanID {
+		traceCtx.Span.SpanID = newSpanID()
+	}
+	if !parent.IsValid() {
+		traceCtx.TraceID = newTraceID()
+		traceCtx.State = ""
+		traceCtx.Span.ParentSpanID = SpanID{}
+	}
+
+	params := SamplingParams{Parent: parent, TraceID: traceCtx.TraceID}
+	if sampler.ShouldSample(params) {
+		traceCtx.Flags |= FlagsSampled
+		traceCtx.StartTime = time.Now()
+		traceCtx.Span.Attributes = make(map[string]interface{})
+	} else {
+		traceCtx.Flags &^= FlagsSampled
+	}
 	return traceCtx
 }
 
+// Sampled 未采样的 span 不记录也不输出
+func (traceCtx *TraceCtx) Sampled() bool {
+	return traceCtx.Flags&FlagsSampled != 0
+}
+
+// SpanContext 当前 span 的追踪信息, 用于传递给下游
+func (traceCtx *TraceCtx) SpanContext() SpanContext {
+	return SpanContext{
+		TraceID:    traceCtx.TraceID,
+		SpanID:     traceCtx.Span.SpanID,
+		Flags:      traceCtx.Flags,
+		TraceState: traceCtx.State,
+	}
+}
+
 // AddAttribute 向 Span 添加自定义属性
 func (traceCtx *TraceCtx) AddAttribute(key string, value interface{}) *TraceCtx {
-	if traceCtx.Span != nil {
-		traceCtx.Span.Attributes[key] = value
-	}
+	traceCtx.update(func(span *Span) {
+		span.deleteTyped(key)
+		span.Attributes[key] = value
+	})
 	return traceCtx
 }
 
-// EndTrace 输出 span 的日志，带有自定义属性（合并到一条日志）
-func (traceCtx *TraceCtx) EndTrace() {
-	if traceCtx.Logger == nil {
+// update 持有锁修改 span, 未采样或已经结束时忽略
+func (traceCtx *TraceCtx) update(f func(span *Span)) {
+	if !traceCtx.Sampled() {
 		return
 	}
+	span := traceCtx.Span
+	span.mu.Lock()
+	if !span.ended {
+		f(span)
+	}
+	span.mu.Unlock()
+}
 
+// EndTrace 输出 span 的日志，带有自定义属性（合并到一条日志）, 并交给 Exporter
+// 可以重复调用, 只有第一次有效
+func (traceCtx *TraceCtx) EndTrace() {
+	if !traceCtx.Sampled() {
+		return
+	}
 	span := traceCtx.Span
-	duration := time.Since(traceCtx.StartTime)
+	span.mu.Lock()
+	if span.ended {
+		span.mu.Unlock()
+		return
+	}
+	span.ended = true
+	span.mu.Unlock()
+
+	// 结束之后 span 不再修改, 不需要持有锁
+	end := time.Now()
+	if exporter != nil {
+		exporter.ExportSpans(traceCtx, []SpanData{traceCtx.data(end)})
+	}
+	if traceCtx.logger == nil {
+		return
+	}
+
+	duration := end.Sub(traceCtx.StartTime)
 	// 合并自定义属性到日志输出字段
 	fields := []zap.Field{
-		zap.String("traceId", traceCtx.TraceID),
+		zap.String("name", span.Name),
+		zap.Stringer("traceId", traceCtx.TraceID),
 		zap.Time("startTime", traceCtx.StartTime),
-		zap.Int("spanId", span.SpanID),
-		zap.Int("parentSpanId", span.ParentSpanID),
+		zap.Stringer("spanId", span.SpanID),
+		zap.Stringer("parentSpanId", span.ParentSpanID),
 		zap.Duration("duration", duration),
 	}
+	// zap 的 caller 是 EndTrace 的位置, 开始 span 的位置另外输出
+	if span.File != "" {
+		fields = append(fields, zap.String("location", span.File+":"+strconv.Itoa(span.Line)))
+	}
 
 	// 自定义属性
 	for key, value := range span.Attributes {
 		fields = append(fields, zap.Any(key, value))
 	}
+	for _, a := range span.typed {
+		fields = append(fields, a.field())
+	}
+
+	level := traceCtx.level
+	if span.Status.Code != StatusUnset {
+		fields = append(fields, zap.Stringer("status", span.Status.Code))
+	}
+	if span.Status.Code == StatusError {
+		fields = append(fields, zap.String("statusMessage", span.Status.Message))
+		// 失败的 span 至少以 Error 级别输出
+		if level < zapcore.ErrorLevel {
+			level = zapcore.ErrorLevel
+		}
+	}
+	if span.Kind != SpanKindInternal {
+		fields = append(fields, zap.Stringer("kind", span.Kind))
+	}
+	if len(span.Events) > 0 {
+		fields = append(fields, zap.Any("events", span.Events))
+	}
+	if len(span.Links) > 0 {
+		links := make([]string, len(span.Links))
+		for i, link := range span.Links {
+			links[i] = link.SpanContext.TraceID.String() + "-" + link.SpanContext.SpanID.String()
+		}
+		fields = append(fields, zap.Strings("links", links))
+	}
 
 	// 输出日志
-	traceCtx.Logger.Check(traceCtx.level, "Trace").Write(fields...)
+	traceCtx.logger.Check(level, "Trace").Write(fields...)
+}
+
+// data 复制一份, 之后修改 span 不影响导出, 需要在结束之后或者持有锁
+func (traceCtx *TraceCtx) data(end time.Time) SpanData {
+	span := traceCtx.Span
+	attrs := make(map[string]interface{}, len(span.Attributes)+len(span.typed))
+	for k, v := range span.Attributes {
+		attrs[k] = v
+	}
+	for _, a := range span.typed {
+		attrs[a.Key] = a.Value()
+	}
+	return SpanData{
+		TraceID:      traceCtx.TraceID,
+		SpanID:       span.SpanID,
+		ParentSpanID: span.ParentSpanID,
+		TraceState:   traceCtx.State,
+		Flags:        traceCtx.Flags,
+		Name:         span.Name,
+		File:         span.File,
+		Line:         span.Line,
+		StartTime:    traceCtx.StartTime,
+		EndTime:      end,
+		Attributes:   attrs,
+		Events:       append([]Event(nil), span.Events...),
+		Links:        append([]Link(nil), span.Links...),
+		Status:       span.Status,
+		Kind:         span.Kind,
+	}
+}
+
+// traceKey 不导出, 避免与其他包的 key 冲突
+type traceKey struct{}
+
+// FromContext 返回 ctx 中最近的 TraceCtx, 没有返回 nil
+func FromContext(ctx context.Context) *TraceCtx {
+	traceCtx, _ := ctx.Value(traceKey{}).(*TraceCtx)
+	return traceCtx
 }
 
 // 实现 context.Context 接口中的 Value 方法
 func (traceCtx *TraceCtx) Value(key interface{}) interface{} {
-	if key == "traceKey" {
+	if key == (traceKey{}) {
 		return traceCtx
 	}
 	return traceCtx.Context.Value(key)
@@ -0,0 +1,116 @@
+package trace
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSpanTree(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	root := New(context.Background(), zap.New(core))
+
+	// root -> a, b; a -> a1, a2; b -> b1
+	a := StartTrace(root)
+	a1 := StartTrace(a)
+	a2 := StartTrace(a)
+	b := StartTrace(root)
+	b1 := StartTrace(b)
+	spans := map[string]*TraceCtx{"root": root, "a": a, "a1": a1, "a2": a2, "b": b, "b1": b1}
+	for _, traceCtx := range spans {
+		traceCtx.EndTrace()
+	}
+
+	// 从日志还原父子关系
+	parents := make(map[string]string)
+	for _, entry := range logs.All() {
+		fields := entry.ContextMap()
+		if fields["traceId"] != root.TraceID.String() {
+			t.Errorf("traceId = %v, want %s", fields["traceId"], root.TraceID)
+		}
+		id := fields["spanId"].(string)
+		if _, ok := parents[id]; ok {
+			t.Fatalf("duplicate span id %s", id)
+		}
+		parents[id] = fields["parentSpanId"].(string)
+	}
+	if len(parents) != len(spans) {
+		t.Fatalf("got %d spans, want %d", len(parents), len(spans))
+	}
+
+	want := map[string]string{"a": "root", "a1": "a", "a2": "a", "b": "root", "b1": "b"}
+	for child, parent := range want {
+		id := spans[child].Span.SpanID.String()
+		if parents[id] != spans[parent].Span.SpanID.String() {
+			t.Errorf("parent of %s = %s, want %s", child, parents[id], parent)
+		}
+	}
+	if parents[root.Span.SpanID.String()] != (SpanID{}).String() {
+		t.Errorf("root has parent %s", parents[root.Span.SpanID.String()])
+	}
+}
+
+func TestContextKey(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "traceKey", "other")
+	traceCtx := StartTrace(ctx)
+	if FromContext(traceCtx) != traceCtx {
+		t.Error("FromContext did not return the span")
+	}
+	if traceCtx.Value("traceKey") != "other" {
+		t.Error("string key collides with trace key")
+	}
+
+	// 包一层普通的 context 仍然可以找到父 span
+	child := StartTrace(context.WithValue(traceCtx, "k", "v"))
+	if child.Span.ParentSpanID != traceCtx.Span.SpanID {
+		t.Error("child lost its parent through a wrapped context")
+	}
+}
+
+// startHelper 封装 StartSpan, 名称和位置应取自 startHelper 的调用者
+func startHelper(ctx context.Context) *TraceCtx {
+	return StartSpan(ctx, "", WithCallerSkip(1))
+}
+
+func TestSpanName(t *testing.T) {
+	root := New(context.Background(), nil)
+	_, file, line, _ := runtime.Caller(0)
+	if root.Span.Name != "github.com/xsean2020/misc/trace.TestSpanName" {
+		t.Errorf("New name = %s", root.Span.Name)
+	}
+	if root.Span.File != file || root.Span.Line != line-1 {
+		t.Errorf("New location = %s:%d, want %s:%d", root.Span.File, root.Span.Line, file, line-1)
+	}
+
+	child := StartTrace(root)
+	_, _, line, _ = runtime.Caller(0)
+	if child.Span.Name != root.Span.Name || child.Span.Line != line-1 {
+		t.Errorf("StartTrace = %s:%d", child.Span.Name, child.Span.Line)
+	}
+
+	named := StartSpan(root, "load user", WithKind(SpanKindClient), WithAttributes(Int("id", 1)))
+	if named.Span.Name != "load user" || named.Span.Kind != SpanKindClient || named.Span.File != file {
+		t.Errorf("StartSpan = %+v", named.Span)
+	}
+	if data := named.data(time.Now()); data.Name != "load user" || data.Attributes["id"] != int64(1) {
+		t.Errorf("data = %+v", data)
+	}
+
+	wrapped := startHelper(root)
+	_, _, line, _ = runtime.Caller(0)
+	if wrapped.Span.Name != root.Span.Name || wrapped.Span.Line != line-1 {
+		t.Errorf("WithCallerSkip = %s:%d, want line %d", wrapped.Span.Name, wrapped.Span.Line, line-1)
+	}
+}
+
+func BenchmarkStartSpan(b *testing.B) {
+	root := New(context.Background(), nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		StartTrace(root)
+	}
+}